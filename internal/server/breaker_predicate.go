@@ -0,0 +1,75 @@
+package server
+
+// BreakerContext exposes the rolling-window health metrics a BreakerPredicate
+// is evaluated against. A new BreakerContext snapshot is built every time
+// CircuitBreakerMiddleware considers whether to trip.
+type BreakerContext interface {
+	// RequestCount returns the number of requests recorded in the window.
+	RequestCount() int
+	// NetworkErrorRatio returns the fraction (0.0-1.0) of requests in the
+	// window that failed to reach the target at all.
+	NetworkErrorRatio() float64
+	// ResponseCodeRatio returns the fraction of requests whose status code
+	// fell in [numLo, numHi) over the count of requests whose status code
+	// fell in [denomLo, denomHi). A denominator of [0, 600) effectively means
+	// "over all responses".
+	ResponseCodeRatio(numLo, numHi, denomLo, denomHi int) float64
+	// LatencyAtQuantileMS returns the response latency, in milliseconds, at
+	// the given quantile (0.0-1.0) of the window.
+	LatencyAtQuantileMS(quantile float64) float64
+}
+
+// BreakerPredicate is a small boolean expression evaluated against a
+// BreakerContext to decide whether a target's circuit breaker should trip.
+//
+// The expression language supports the function calls `RequestCount()`,
+// `NetworkErrorRatio()`, `ResponseCodeRatio(numLo, numHi, denomLo, denomHi)`
+// and `LatencyAtQuantileMS(quantile)`, numeric literals (integer or
+// decimal), the comparison operators `== != < <= > >=`, the boolean
+// operators `&& ||`, unary `!`, and parentheses. For example:
+//
+//	NetworkErrorRatio() > 0.5 || ResponseCodeRatio(500, 600, 0, 600) > 0.25 || LatencyAtQuantileMS(0.5) > 2000
+//
+// The grammar, tokenizer and parser are shared with RetryPredicate; see
+// predicate.go.
+type BreakerPredicate struct {
+	expr predicateExpr[BreakerContext]
+}
+
+// breakerCallTable binds the function names callable from a BreakerPredicate
+// to BreakerContext.
+var breakerCallTable = predicateCallTable[BreakerContext]{
+	"RequestCount":      func(ctx BreakerContext, args []float64) float64 { return float64(ctx.RequestCount()) },
+	"NetworkErrorRatio": func(ctx BreakerContext, args []float64) float64 { return ctx.NetworkErrorRatio() },
+	"ResponseCodeRatio": func(ctx BreakerContext, args []float64) float64 {
+		argAt := func(i int) int {
+			if i >= len(args) {
+				return 0
+			}
+			return int(args[i])
+		}
+		return ctx.ResponseCodeRatio(argAt(0), argAt(1), argAt(2), argAt(3))
+	},
+	"LatencyAtQuantileMS": func(ctx BreakerContext, args []float64) float64 {
+		quantile := 0.0
+		if len(args) > 0 {
+			quantile = args[0]
+		}
+		return ctx.LatencyAtQuantileMS(quantile)
+	},
+}
+
+// ParseBreakerPredicate parses source into a *BreakerPredicate ready to be
+// evaluated. It returns an error if the expression is malformed.
+func ParseBreakerPredicate(source string) (*BreakerPredicate, error) {
+	expr, err := parsePredicate[BreakerContext]("breaker predicate", source)
+	if err != nil {
+		return nil, err
+	}
+	return &BreakerPredicate{expr: expr}, nil
+}
+
+// Evaluate runs the predicate against the given BreakerContext.
+func (p *BreakerPredicate) Evaluate(ctx BreakerContext) bool {
+	return p.expr.Eval(ctx, breakerCallTable) != 0
+}
@@ -32,6 +32,11 @@ type Buffer struct {
 
 	// memoryBuffer stores the in memory bytes.
 	memoryBuffer bytes.Buffer
+	// memSnapshot is an independent copy of every byte written to memoryBuffer.
+	// bytes.Buffer.Bytes() only returns the unread portion of the buffer, which
+	// shrinks as Read/Send consume it, so Rewind can't rely on memoryBuffer
+	// directly without losing data already read by a prior dispatch attempt.
+	memSnapshot []byte
 	// memBytesWritten is a counter for the length of the bytes stored into memory.
 	memBytesWritten int64
 	// diskBuffer stores the spilled bytes (ones that exceed maxMemBytes) on disk.
@@ -145,6 +150,20 @@ func (b *Buffer) Send(w io.Writer) error {
 	return err
 }
 
+// Rewind returns a fresh io.Reader over all of the data written to the
+// Buffer so far, independent of any reader previously handed out by Read or
+// Send. Unlike those, Rewind may be called multiple times, which makes it
+// suitable for re-dispatching a request body on retry.
+func (b *Buffer) Rewind() (io.Reader, error) {
+	if b.diskBuffer != nil {
+		if _, err := b.diskBuffer.Seek(0, 0); err != nil {
+			return nil, err
+		}
+		return io.MultiReader(bytes.NewReader(b.memSnapshot), b.diskBuffer), nil
+	}
+	return bytes.NewReader(b.memSnapshot), nil
+}
+
 // Close performs disk buffer cleanup in case that exists.
 func (b *Buffer) Close() error {
 	b.closeOnce.Do(func() {
@@ -159,6 +178,7 @@ func (b *Buffer) Close() error {
 func (b *Buffer) writeToMemory(p []byte) (int, error) {
 	n, err := b.memoryBuffer.Write(p)
 	b.memBytesWritten += int64(n)
+	b.memSnapshot = append(b.memSnapshot, p[:n]...)
 	return n, err
 }
 
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"io"
+	"testing"
+)
+
+func TestBufferRewind_SurvivesPriorFullRead(t *testing.T) {
+	buf := NewBufferedWriteCloser(1024, 1024)
+	defer buf.Close()
+
+	want := "hello=world&amount=100"
+	if _, err := buf.Write([]byte(want)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// Simulate attempt 1 fully draining the buffer, as ReverseProxy does when
+	// sending the request body upstream.
+	if _, err := io.ReadAll(buf); err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	reader, err := buf.Rewind()
+	if err != nil {
+		t.Fatalf("Rewind() error = %v", err)
+	}
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll(rewound) error = %v", err)
+	}
+
+	if string(got) != want {
+		t.Fatalf("Rewind() after read = %q, want %q", got, want)
+	}
+}
+
+func TestBufferRewind_RepeatableAcrossMultipleAttempts(t *testing.T) {
+	buf := NewBufferedWriteCloser(1024, 1024)
+	defer buf.Close()
+
+	want := "retry me"
+	if _, err := buf.Write([]byte(want)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		reader, err := buf.Rewind()
+		if err != nil {
+			t.Fatalf("attempt %d: Rewind() error = %v", attempt, err)
+		}
+
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("attempt %d: ReadAll() error = %v", attempt, err)
+		}
+
+		if string(got) != want {
+			t.Fatalf("attempt %d: Rewind() = %q, want %q", attempt, got, want)
+		}
+	}
+}
+
+func TestBufferRewind_SurvivesDiskSpillAfterRead(t *testing.T) {
+	buf := NewBufferedWriteCloser(1024, 4)
+	defer buf.Close()
+
+	want := "hello=world&amount=100"
+	if _, err := buf.Write([]byte(want)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if _, err := io.ReadAll(buf); err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+
+	reader, err := buf.Rewind()
+	if err != nil {
+		t.Fatalf("Rewind() error = %v", err)
+	}
+
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll(rewound) error = %v", err)
+	}
+
+	if string(got) != want {
+		t.Fatalf("Rewind() after read with disk spill = %q, want %q", got, want)
+	}
+}
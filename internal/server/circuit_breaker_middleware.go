@@ -0,0 +1,363 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState describes where a CircuitBreakerMiddleware is in its
+// trip/cooldown/probe cycle.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerMaxSamplesPerBucket bounds the number of latency samples kept per
+// bucket, so a very busy window can't grow memory unboundedly.
+const breakerMaxSamplesPerBucket = 128
+
+// defaultBreakerWindowDuration is used when NewCircuitBreakerMiddleware is
+// given a zero windowDuration, e.g. an unconfigured breaker. Without this,
+// bucketDuration (windowDuration / bucketCount) would be zero and
+// currentBucketLocked's division by it would panic on the first request.
+const defaultBreakerWindowDuration = time.Minute
+
+// breakerBucket accumulates outcomes for a single slice of the rolling
+// window.
+type breakerBucket struct {
+	requests      int
+	networkErrors int
+	codeClasses   [6]int // index i holds the count of responses with status i*100..i*100+99, clamped to [0,5]
+	latenciesMS   []float64
+}
+
+// CircuitBreakerMiddleware is a sibling to RequestBufferMiddleware that
+// short-circuits requests to a target once it looks unhealthy, instead of
+// letting the reverse proxy dial it on every request.
+//
+// Outcomes are tracked in a ring of buckets spanning windowDuration; a
+// BreakerPredicate is evaluated against the aggregated window after every
+// request while the breaker is Closed. Once it trips, the breaker rejects
+// requests with Fallback for cooldown, then moves to HalfOpen where it
+// admits a small fraction of probe requests: a single successful probe
+// closes the breaker again, a single failed one reopens it.
+//
+// Healthy reports the same signal that gates admission here, so that target
+// selection can exclude a tripped target before ever routing to it: pass the
+// middleware guarding the rollout target to RolloutController's
+// SetRolloutTargetHealth to wire the two together.
+type CircuitBreakerMiddleware struct {
+	predicate          *BreakerPredicate
+	cooldown           time.Duration
+	halfOpenProbeRatio float64
+	fallback           func(w http.ResponseWriter, r *http.Request, cooldown time.Duration)
+
+	bucketCount    int
+	bucketDuration time.Duration
+
+	next http.Handler
+
+	mu          sync.Mutex
+	state       BreakerState
+	openedAt    time.Time
+	buckets     []breakerBucket
+	bucketSlots []time.Time
+	probeTokens float64
+}
+
+// NewCircuitBreakerMiddleware builds a CircuitBreakerMiddleware that trips
+// when predicate matches the outcomes observed over the trailing
+// windowDuration, split into bucketCount buckets. Once tripped, it stays
+// Open for cooldown before probing again, admitting roughly
+// halfOpenProbeRatio (0.0-1.0) of requests while HalfOpen. A nil fallback
+// defaults to 503 with a Retry-After header set to the cooldown.
+//
+// The request asked for this to be parsed at service-register time, touching
+// service, middleware and metrics packages. This repo slice only contains
+// internal/server, so there's no service-register entry point here to parse
+// it from; the caller that owns that surface is responsible for constructing
+// a BreakerPredicate (see ParseBreakerPredicate) from its config and passing
+// it here.
+func NewCircuitBreakerMiddleware(windowDuration time.Duration, bucketCount int, predicate *BreakerPredicate, cooldown time.Duration, halfOpenProbeRatio float64, fallback func(w http.ResponseWriter, r *http.Request, cooldown time.Duration), next http.Handler) *CircuitBreakerMiddleware {
+	if bucketCount < 1 {
+		bucketCount = 1
+	}
+	if windowDuration <= 0 {
+		windowDuration = defaultBreakerWindowDuration
+	}
+	if fallback == nil {
+		fallback = defaultBreakerFallback
+	}
+
+	return &CircuitBreakerMiddleware{
+		predicate:          predicate,
+		cooldown:           cooldown,
+		halfOpenProbeRatio: halfOpenProbeRatio,
+		fallback:           fallback,
+		bucketCount:        bucketCount,
+		bucketDuration:     windowDuration / time.Duration(bucketCount),
+		next:               next,
+		buckets:            make([]breakerBucket, bucketCount),
+		bucketSlots:        make([]time.Time, bucketCount),
+	}
+}
+
+func defaultBreakerFallback(w http.ResponseWriter, r *http.Request, cooldown time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(cooldown.Seconds())))
+	http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+}
+
+// Healthy reports whether the breaker currently allows requests through. A
+// HalfOpen breaker is reported as unhealthy, since it only admits a small
+// probe fraction and shouldn't be treated as a normal routing candidate.
+func (cb *CircuitBreakerMiddleware) Healthy() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state == BreakerClosed
+}
+
+// State returns the breaker's current state, mostly useful for admin/status
+// surfaces.
+func (cb *CircuitBreakerMiddleware) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreakerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !cb.admit() {
+		cb.fallback(w, r, cb.cooldown)
+		return
+	}
+
+	started := time.Now()
+	writer := newBreakerObservingWriter(w)
+	cb.next.ServeHTTP(writer, r)
+	elapsed := time.Since(started)
+
+	cb.recordOutcome(writer.statusCode, writer.isNetworkError(), elapsed)
+}
+
+// breakerObservingWriter passes every call straight through to the real
+// ResponseWriter, observing only the status code and the presence of
+// HeaderNetworkError, so the circuit breaker can record an outcome without
+// buffering the response body.
+type breakerObservingWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func newBreakerObservingWriter(w http.ResponseWriter) *breakerObservingWriter {
+	return &breakerObservingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+func (w *breakerObservingWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *breakerObservingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *breakerObservingWriter) isNetworkError() bool {
+	return w.Header().Get(HeaderNetworkError) != ""
+}
+
+func (w *breakerObservingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// admit decides whether the current request should be let through, applying
+// Open -> HalfOpen cooldown transitions along the way.
+func (cb *CircuitBreakerMiddleware) admit() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerOpen {
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.probeTokens = 0
+	}
+
+	if cb.state == BreakerHalfOpen {
+		cb.probeTokens += cb.halfOpenProbeRatio
+		if cb.probeTokens < 1 {
+			return false
+		}
+		cb.probeTokens--
+	}
+
+	return true
+}
+
+func (cb *CircuitBreakerMiddleware) recordOutcome(statusCode int, isNetworkError bool, elapsed time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	bucket := cb.currentBucketLocked()
+	bucket.requests++
+	if isNetworkError {
+		bucket.networkErrors++
+	} else if statusCode > 0 {
+		class := statusCode / 100
+		if class < 0 {
+			class = 0
+		}
+		if class > 5 {
+			class = 5
+		}
+		bucket.codeClasses[class]++
+	}
+	if len(bucket.latenciesMS) < breakerMaxSamplesPerBucket {
+		bucket.latenciesMS = append(bucket.latenciesMS, float64(elapsed.Milliseconds()))
+	}
+
+	switch cb.state {
+	case BreakerHalfOpen:
+		if isNetworkError || statusCode >= 500 {
+			cb.state = BreakerOpen
+			cb.openedAt = time.Now()
+		} else {
+			cb.state = BreakerClosed
+			cb.resetWindowLocked()
+		}
+	case BreakerClosed:
+		if cb.predicate != nil && cb.predicate.Evaluate(cb.snapshotLocked()) {
+			cb.state = BreakerOpen
+			cb.openedAt = time.Now()
+		}
+	}
+}
+
+// currentBucketLocked returns the bucket for "now", resetting it first if
+// its time slot has rolled over since it was last used. Callers must hold
+// cb.mu.
+func (cb *CircuitBreakerMiddleware) currentBucketLocked() *breakerBucket {
+	now := time.Now()
+	slot := now.Truncate(cb.bucketDuration)
+	idx := int((now.UnixNano() / int64(cb.bucketDuration)) % int64(cb.bucketCount))
+	if idx < 0 {
+		idx += cb.bucketCount
+	}
+
+	if !cb.bucketSlots[idx].Equal(slot) {
+		cb.buckets[idx] = breakerBucket{}
+		cb.bucketSlots[idx] = slot
+	}
+
+	return &cb.buckets[idx]
+}
+
+// resetWindowLocked discards all recorded outcomes, used when the breaker
+// closes again after a successful probe so that stale pre-trip data doesn't
+// immediately re-trip it. Callers must hold cb.mu.
+func (cb *CircuitBreakerMiddleware) resetWindowLocked() {
+	cb.buckets = make([]breakerBucket, cb.bucketCount)
+	cb.bucketSlots = make([]time.Time, cb.bucketCount)
+}
+
+func (cb *CircuitBreakerMiddleware) snapshotLocked() *breakerSnapshot {
+	snapshot := &breakerSnapshot{}
+	for _, bucket := range cb.buckets {
+		snapshot.requests += bucket.requests
+		snapshot.networkErrors += bucket.networkErrors
+		for class, count := range bucket.codeClasses {
+			snapshot.codeClasses[class] += count
+		}
+		snapshot.latenciesMS = append(snapshot.latenciesMS, bucket.latenciesMS...)
+	}
+	return snapshot
+}
+
+// breakerSnapshot is the aggregated view of the rolling window handed to a
+// BreakerPredicate for evaluation.
+type breakerSnapshot struct {
+	requests      int
+	networkErrors int
+	codeClasses   [6]int
+	latenciesMS   []float64
+}
+
+func (s *breakerSnapshot) RequestCount() int {
+	return s.requests
+}
+
+func (s *breakerSnapshot) NetworkErrorRatio() float64 {
+	if s.requests == 0 {
+		return 0
+	}
+	return float64(s.networkErrors) / float64(s.requests)
+}
+
+// ResponseCodeRatio works on per-class counts (1xx, 2xx, ... 5xx) rather than
+// on individual status codes: a class counts toward a range when its lower
+// bound (e.g. 500 for 5xx) lies inside it, so a bound that isn't a multiple
+// of 100 doesn't select individual codes. Network errors carry no status
+// code and count toward neither range.
+func (s *breakerSnapshot) ResponseCodeRatio(numLo, numHi, denomLo, denomHi int) float64 {
+	sumInRange := func(lo, hi int) int {
+		total := 0
+		for class, count := range s.codeClasses {
+			representative := class * 100
+			if representative >= lo && representative < hi {
+				total += count
+			}
+		}
+		return total
+	}
+
+	denominator := sumInRange(denomLo, denomHi)
+	if denominator == 0 {
+		return 0
+	}
+	return float64(sumInRange(numLo, numHi)) / float64(denominator)
+}
+
+func (s *breakerSnapshot) LatencyAtQuantileMS(quantile float64) float64 {
+	if len(s.latenciesMS) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64(nil), s.latenciesMS...)
+	sort.Float64s(sorted)
+
+	if quantile < 0 {
+		quantile = 0
+	}
+	if quantile > 1 {
+		quantile = 1
+	}
+
+	idx := int(quantile * float64(len(sorted)-1))
+	return sorted[idx]
+}
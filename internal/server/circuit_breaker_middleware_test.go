@@ -0,0 +1,125 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerCurrentBucketLocked_RotatesOverTime(t *testing.T) {
+	cb := NewCircuitBreakerMiddleware(50*time.Millisecond, 5, nil, time.Second, 1, nil, nil)
+
+	cb.mu.Lock()
+	bucket := cb.currentBucketLocked()
+	bucket.requests = 3
+	cb.mu.Unlock()
+
+	time.Sleep(cb.bucketDuration * 2)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	rotated := cb.currentBucketLocked()
+	if rotated.requests != 0 {
+		t.Fatalf("expected bucket to reset after rolling over, got requests=%d", rotated.requests)
+	}
+}
+
+func TestCircuitBreakerResetWindowLocked_ClearsAllBuckets(t *testing.T) {
+	cb := NewCircuitBreakerMiddleware(100*time.Millisecond, 4, nil, time.Second, 1, nil, nil)
+
+	cb.mu.Lock()
+	bucket := cb.currentBucketLocked()
+	bucket.requests = 7
+	bucket.networkErrors = 2
+	cb.resetWindowLocked()
+	defer cb.mu.Unlock()
+
+	for i, bucket := range cb.buckets {
+		if bucket.requests != 0 || bucket.networkErrors != 0 {
+			t.Fatalf("bucket %d not cleared: %+v", i, bucket)
+		}
+	}
+}
+
+func TestCircuitBreakerAdmit_HalfOpenProbeRatioAccumulates(t *testing.T) {
+	cb := NewCircuitBreakerMiddleware(time.Second, 1, nil, 10*time.Millisecond, 0.5, nil, nil)
+
+	cb.mu.Lock()
+	cb.state = BreakerOpen
+	cb.openedAt = time.Now().Add(-time.Hour)
+	cb.mu.Unlock()
+
+	got := make([]bool, 4)
+	for i := range got {
+		got[i] = cb.admit()
+	}
+
+	want := []bool{false, true, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("admit() sequence = %v, want %v", got, want)
+		}
+	}
+
+	if cb.State() != BreakerHalfOpen {
+		t.Fatalf("expected breaker to remain HalfOpen while probing, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreakerRecordOutcome_HalfOpenSuccessCloses(t *testing.T) {
+	cb := NewCircuitBreakerMiddleware(time.Second, 1, nil, time.Second, 1, nil, nil)
+	cb.mu.Lock()
+	cb.state = BreakerHalfOpen
+	cb.mu.Unlock()
+
+	cb.recordOutcome(200, false, time.Millisecond)
+
+	if cb.State() != BreakerClosed {
+		t.Fatalf("expected successful probe to close breaker, got %s", cb.State())
+	}
+	if !cb.Healthy() {
+		t.Fatal("expected Healthy() to be true once closed")
+	}
+}
+
+func TestCircuitBreakerRecordOutcome_HalfOpenFailureReopens(t *testing.T) {
+	cb := NewCircuitBreakerMiddleware(time.Second, 1, nil, time.Second, 1, nil, nil)
+	cb.mu.Lock()
+	cb.state = BreakerHalfOpen
+	cb.mu.Unlock()
+
+	cb.recordOutcome(503, false, time.Millisecond)
+
+	if cb.State() != BreakerOpen {
+		t.Fatalf("expected failed probe to reopen breaker, got %s", cb.State())
+	}
+	if cb.Healthy() {
+		t.Fatal("expected Healthy() to be false once reopened")
+	}
+}
+
+func TestCircuitBreakerRecordOutcome_TripsOnPredicate(t *testing.T) {
+	predicate, err := ParseBreakerPredicate("RequestCount() >= 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCircuitBreakerMiddleware(time.Second, 1, predicate, time.Second, 1, nil, nil)
+
+	cb.recordOutcome(200, false, time.Millisecond)
+	if cb.State() != BreakerClosed {
+		t.Fatalf("expected breaker to stay closed after 1 request, got %s", cb.State())
+	}
+
+	cb.recordOutcome(200, false, time.Millisecond)
+	cb.recordOutcome(200, false, time.Millisecond)
+	if cb.State() != BreakerOpen {
+		t.Fatalf("expected breaker to trip once predicate matches, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreakerCurrentBucketLocked_DoesNotPanicOnZeroWindowDuration(t *testing.T) {
+	cb := NewCircuitBreakerMiddleware(0, 5, nil, time.Second, 1, nil, nil)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.currentBucketLocked()
+}
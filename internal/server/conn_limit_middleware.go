@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/basecamp/kamal-proxy/internal/metrics"
+)
+
+// ConnLimitMiddleware caps the number of concurrently in-flight requests per
+// service and, if an identity can be extracted, per identity (client IP,
+// header or cookie, via the same pluggable RolloutIdentity used for rollout
+// percentages). Requests past the limit either queue for up to maxWait, or
+// are rejected outright with 429 once maxQueueDepth waiters have already
+// piled up. Rejections are tracked via metrics.Tracker, alongside the
+// request-level counters LoggingMiddleware already records.
+type ConnLimitMiddleware struct {
+	perIdentityLimit int
+	identity         RolloutIdentity
+	maxQueueDepth    int64
+	maxWait          time.Duration
+	next             http.Handler
+
+	serviceSem chan struct{}
+
+	mu                 sync.Mutex
+	identitySemaphores map[string]*identitySemaphore
+	lastSwept          time.Time
+	queueDepth         int64
+}
+
+// identitySemaphore pairs a per-identity semaphore with the last time it was
+// used, so identitySemaphoreFor can evict entries for identities that have
+// gone idle instead of growing the map forever.
+type identitySemaphore struct {
+	sem      chan struct{}
+	lastUsed time.Time
+}
+
+// identitySemaphoreIdleTimeout bounds how long an identity's semaphore is
+// kept around after its last use before being garbage collected.
+const identitySemaphoreIdleTimeout = 10 * time.Minute
+
+// NewConnLimitMiddleware builds a ConnLimitMiddleware. perServiceLimit is
+// the hard cap of concurrent requests across the whole service;
+// perIdentityLimit (0 disables it) additionally caps concurrency per
+// identity. Up to maxQueueDepth requests may wait for a free slot, each for
+// at most maxWait, before being rejected with 429.
+//
+// The request asked for this to be exposed as per-service configuration
+// through the kamal-proxy CLI. This repo slice only contains
+// internal/server, so there's no CLI here to surface it through; the caller
+// that owns that surface is responsible for constructing the middleware from
+// its own per-service config.
+func NewConnLimitMiddleware(perServiceLimit, perIdentityLimit int, identity RolloutIdentity, maxQueueDepth int, maxWait time.Duration, next http.Handler) *ConnLimitMiddleware {
+	return &ConnLimitMiddleware{
+		perIdentityLimit:   perIdentityLimit,
+		identity:           identity,
+		maxQueueDepth:      int64(maxQueueDepth),
+		maxWait:            maxWait,
+		next:               next,
+		serviceSem:         make(chan struct{}, perServiceLimit),
+		identitySemaphores: map[string]*identitySemaphore{},
+	}
+}
+
+func (h *ConnLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.maxWait)
+	defer cancel()
+
+	if !h.acquire(ctx, h.serviceSem) {
+		h.reject(w, r)
+		return
+	}
+	defer releaseSemaphore(h.serviceSem)
+
+	identitySem := h.identitySemaphoreFor(r)
+	if identitySem != nil {
+		if !h.acquire(ctx, identitySem) {
+			h.reject(w, r)
+			return
+		}
+		defer releaseSemaphore(identitySem)
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// acquire takes a slot from sem, counting the caller against queueDepth only
+// while it's actually waiting for a free slot rather than for however long
+// the request then takes to serve. Otherwise maxQueueDepth would behave as a
+// hidden global concurrency ceiling on top of (and typically far below)
+// perServiceLimit, rejecting requests with 429 while semaphore slots sit
+// free.
+func (h *ConnLimitMiddleware) acquire(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(&h.queueDepth, 1) > h.maxQueueDepth {
+		atomic.AddInt64(&h.queueDepth, -1)
+		return false
+	}
+	defer atomic.AddInt64(&h.queueDepth, -1)
+
+	return acquireSemaphore(ctx, sem)
+}
+
+func (h *ConnLimitMiddleware) identitySemaphoreFor(r *http.Request) chan struct{} {
+	if h.perIdentityLimit <= 0 || h.identity == nil {
+		return nil
+	}
+
+	value, ok := h.identity.Identity(r)
+	if !ok {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.sweepIdleIdentitiesLocked(now)
+
+	entry, ok := h.identitySemaphores[value]
+	if !ok {
+		entry = &identitySemaphore{sem: make(chan struct{}, h.perIdentityLimit)}
+		h.identitySemaphores[value] = entry
+	}
+	entry.lastUsed = now
+	return entry.sem
+}
+
+// sweepIdleIdentitiesLocked evicts semaphores for identities that haven't
+// been seen in identitySemaphoreIdleTimeout, throttled to run at most once
+// per timeout window. Callers must hold h.mu.
+func (h *ConnLimitMiddleware) sweepIdleIdentitiesLocked(now time.Time) {
+	if now.Sub(h.lastSwept) < identitySemaphoreIdleTimeout {
+		return
+	}
+	h.lastSwept = now
+
+	for value, entry := range h.identitySemaphores {
+		if now.Sub(entry.lastUsed) >= identitySemaphoreIdleTimeout {
+			delete(h.identitySemaphores, value)
+		}
+	}
+}
+
+func (h *ConnLimitMiddleware) reject(w http.ResponseWriter, r *http.Request) {
+	lrc := LoggingRequestContext(r)
+	lrc.SetAttr("rate_limited", "true")
+	metrics.Tracker.TrackConnLimited(lrc.Service)
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(h.maxWait.Seconds())))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
+
+// acquireSemaphore attempts to push a token into sem, giving up once ctx is
+// done. It returns false on timeout/cancellation.
+func acquireSemaphore(ctx context.Context, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func releaseSemaphore(sem chan struct{}) {
+	<-sem
+}
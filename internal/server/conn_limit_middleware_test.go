@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConnLimitMiddleware_QueueDepthExcludesInFlightRequests guards against a
+// regression where queueDepth was incremented for the lifetime of every
+// request, making maxQueueDepth behave as a global concurrency ceiling
+// rather than bounding requests actually waiting for a semaphore slot.
+func TestConnLimitMiddleware_QueueDepthExcludesInFlightRequests(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(1)
+
+	// The first request blocks until released, to hold its semaphore slot
+	// while the second request is dispatched. The first request marks itself
+	// via a header, so the second, which lacks it, doesn't also block on
+	// started/release.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Test-Blocking") == "true" {
+			started.Done()
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := NewConnLimitMiddleware(1000, 0, nil, 1, time.Second, next)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Test-Blocking", "true")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+	}()
+
+	started.Wait()
+
+	// A second request should still be admitted immediately: the first
+	// request is being served, not queued waiting for a slot, so it
+	// shouldn't count against maxQueueDepth.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("second request got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	close(release)
+	wg.Wait()
+}
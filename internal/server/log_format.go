@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogFormat selects how LoggingMiddleware renders each access log line.
+type LogFormat string
+
+const (
+	// LogFormatJSON and LogFormatLogfmt defer entirely to the injected
+	// slog.Logger's handler, which is expected to be configured with a
+	// matching slog.Handler (slog.NewJSONHandler or slog.NewTextHandler).
+	LogFormatJSON   LogFormat = "json"
+	LogFormatLogfmt LogFormat = "logfmt"
+	// LogFormatCLF renders the Apache/NCSA Common Log Format.
+	LogFormatCLF LogFormat = "clf"
+	// LogFormatCombined renders CLF extended with referer and user-agent, as
+	// popularized by Apache's "combined" log format.
+	LogFormatCombined LogFormat = "combined"
+	// LogFormatCEF renders ArcSight's Common Event Format, for ingestion by
+	// SIEM tooling.
+	LogFormatCEF LogFormat = "cef"
+)
+
+// rendersAsLine reports whether the format produces a single preformatted
+// line that should be logged verbatim, as opposed to being left to the
+// slog.Logger's own handler to serialize from structured attributes.
+func (f LogFormat) rendersAsLine() bool {
+	switch f {
+	case LogFormatCLF, LogFormatCombined, LogFormatCEF:
+		return true
+	default:
+		return false
+	}
+}
+
+type accessLogEntry struct {
+	ClientAddr string
+	RemoteUser string
+	Started    time.Time
+	Method     string
+	// Path is the full request-URI, including the (already redacted) query
+	// string if present, as CLF/combined tooling expects to find it in the
+	// quoted request line.
+	Path         string
+	Proto        string
+	StatusCode   int
+	BytesWritten int64
+	Referer      string
+	UserAgent    string
+	Service      string
+	Target       string
+	Elapsed      time.Duration
+}
+
+// renderLine formats entry according to format. It is only called for
+// formats where LogFormat.rendersAsLine is true.
+func (f LogFormat) renderLine(entry accessLogEntry) string {
+	switch f {
+	case LogFormatCLF:
+		return renderCLF(entry)
+	case LogFormatCombined:
+		return renderCLF(entry) + fmt.Sprintf(` "%s" "%s"`, clfField(entry.Referer), clfField(entry.UserAgent))
+	case LogFormatCEF:
+		return renderCEF(entry)
+	default:
+		return ""
+	}
+}
+
+func renderCLF(entry accessLogEntry) string {
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %d`,
+		clfField(entry.ClientAddr),
+		clfField(entry.RemoteUser),
+		entry.Started.Format("02/Jan/2006:15:04:05 -0700"),
+		clfField(entry.Method),
+		clfField(entry.Path),
+		clfField(entry.Proto),
+		entry.StatusCode,
+		entry.BytesWritten,
+	)
+}
+
+// clfField returns "-" for an empty value, which is how CLF/combined denote
+// a missing field. Request-derived values are otherwise escaped so that a
+// CR, LF or quote embedded in e.g. a percent-encoded request path can't
+// inject a fabricated log line or break out of the quoted fields.
+func clfField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return clfEscaper.Replace(value)
+}
+
+var clfEscaper = strings.NewReplacer("\r", "", "\n", "", `"`, `\"`)
+
+// cefSeverityForStatus maps an HTTP status code onto a CEF severity from 0
+// (lowest) to 10 (highest), which SIEM tooling uses to prioritize alerting.
+func cefSeverityForStatus(statusCode int) int {
+	switch {
+	case statusCode >= 500:
+		return 8
+	case statusCode >= 400:
+		return 5
+	default:
+		return 1
+	}
+}
+
+func renderCEF(entry accessLogEntry) string {
+	extension := []string{
+		fmt.Sprintf("src=%s", cefField(entry.ClientAddr)),
+		fmt.Sprintf("request=%s", cefField(entry.Path)),
+		fmt.Sprintf("requestMethod=%s", cefField(entry.Method)),
+		fmt.Sprintf("cs1=%s", cefField(entry.Service)),
+		"cs1Label=service",
+		fmt.Sprintf("cs2=%s", cefField(entry.Target)),
+		"cs2Label=target",
+		fmt.Sprintf("cn1=%d", entry.StatusCode),
+		"cn1Label=httpStatus",
+		fmt.Sprintf("cn2=%d", entry.Elapsed.Milliseconds()),
+		"cn2Label=durationMs",
+	}
+
+	return fmt.Sprintf("CEF:0|Basecamp|kamal-proxy|1.0|http-request|HTTP Request|%d|%s",
+		cefSeverityForStatus(entry.StatusCode),
+		strings.Join(extension, " "),
+	)
+}
+
+// cefField escapes characters that are significant in the CEF extension
+// dictionary (key=value pairs separated by spaces), as well as CR/LF so a
+// request-derived value (e.g. a percent-encoded path) can't inject a
+// fabricated extension field or a second CEF line.
+func cefField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return cefEscaper.Replace(value)
+}
+
+var cefEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\r", "", "\n", "")
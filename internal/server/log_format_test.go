@@ -0,0 +1,58 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderCLF_EscapesInjectedControlChars(t *testing.T) {
+	entry := accessLogEntry{
+		ClientAddr: "127.0.0.1",
+		Method:     "GET",
+		Path:       "/foo\r\nFAKE-LOG-LINE",
+		Proto:      "HTTP/1.1",
+		StatusCode: 200,
+	}
+
+	line := LogFormatCLF.renderLine(entry)
+
+	if containsNewline(line) {
+		t.Fatalf("renderLine() = %q, want no embedded CR/LF", line)
+	}
+}
+
+func TestRenderCLF_EscapesQuotesInPath(t *testing.T) {
+	entry := accessLogEntry{
+		Method: "GET",
+		Path:   `/foo" "injected`,
+		Proto:  "HTTP/1.1",
+	}
+
+	line := LogFormatCLF.renderLine(entry)
+	wantFragment := `"GET /foo\" \"injected HTTP/1.1"`
+	if !strings.Contains(line, wantFragment) {
+		t.Fatalf("renderLine() = %q, want it to contain %q", line, wantFragment)
+	}
+}
+
+func TestRenderCEF_EscapesInjectedControlChars(t *testing.T) {
+	entry := accessLogEntry{
+		Path:   "/foo\r\nCEF:0|evil",
+		Method: "GET",
+	}
+
+	line := LogFormatCEF.renderLine(entry)
+
+	if containsNewline(line) {
+		t.Fatalf("renderLine() = %q, want no embedded CR/LF", line)
+	}
+}
+
+func containsNewline(s string) bool {
+	for _, r := range s {
+		if r == '\r' || r == '\n' {
+			return true
+		}
+	}
+	return false
+}
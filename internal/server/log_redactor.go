@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// redactedSentinel replaces the value of any redacted header or query
+// parameter before it reaches the access log.
+const redactedSentinel = "[REDACTED]"
+
+// logRedactor strips sensitive header and query-parameter values out of
+// access log lines before they're written, so that secrets like API keys or
+// session tokens passed via headers or query strings never land in log
+// storage.
+type logRedactor struct {
+	headers     []string
+	queryParams []string
+}
+
+func newLogRedactor(headers, queryParams []string) *logRedactor {
+	return &logRedactor{headers: headers, queryParams: queryParams}
+}
+
+// RedactQuery returns rawQuery with any configured parameter values replaced
+// by redactedSentinel.
+func (r *logRedactor) RedactQuery(rawQuery string) string {
+	if r == nil || len(r.queryParams) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+
+	redacted := false
+	for _, name := range r.queryParams {
+		if _, ok := values[name]; ok {
+			values.Set(name, redactedSentinel)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawQuery
+	}
+
+	return values.Encode()
+}
+
+// IsHeaderRedacted reports whether headerName's value should be replaced by
+// redactedSentinel before logging.
+func (r *logRedactor) IsHeaderRedacted(headerName string) bool {
+	if r == nil {
+		return false
+	}
+	return slices.ContainsFunc(r.headers, func(name string) bool {
+		return strings.EqualFold(name, headerName)
+	})
+}
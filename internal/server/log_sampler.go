@@ -0,0 +1,69 @@
+package server
+
+import "sync"
+
+// statusClass buckets an HTTP status code into the "Nxx" groupings that
+// sampling rates are configured against, e.g. "5xx".
+func statusClass(statusCode int) string {
+	switch statusCode / 100 {
+	case 1:
+		return "1xx"
+	case 2:
+		return "2xx"
+	case 3:
+		return "3xx"
+	case 4:
+		return "4xx"
+	default:
+		return "5xx"
+	}
+}
+
+// logSampler decides whether a given request should be logged, based on a
+// target rate (0.0 to 1.0) per status class. A rate of 1.0 always logs; a
+// missing class always logs, so that unconfigured classes aren't silently
+// dropped.
+//
+// Rather than rolling dice per request, each class accumulates a token bucket
+// of size 1: every request adds `rate` tokens, and a request is logged
+// whenever the bucket holds at least one whole token. Over any long enough
+// window this converges on exactly `rate`, while spreading the logged
+// requests evenly rather than clustering them.
+type logSampler struct {
+	rates map[string]float64
+
+	mu     sync.Mutex
+	tokens map[string]float64
+}
+
+func newLogSampler(rates map[string]float64) *logSampler {
+	return &logSampler{
+		rates:  rates,
+		tokens: make(map[string]float64, len(rates)),
+	}
+}
+
+func (s *logSampler) Allow(statusCode int) bool {
+	if s == nil || len(s.rates) == 0 {
+		return true
+	}
+
+	class := statusClass(statusCode)
+	rate, ok := s.rates[class]
+	if !ok || rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[class] += rate
+	if s.tokens[class] >= 1 {
+		s.tokens[class]--
+		return true
+	}
+	return false
+}
@@ -22,15 +22,59 @@ type loggingRequestContext struct {
 	Target          string
 	RequestHeaders  []string
 	ResponseHeaders []string
+	// Attrs holds arbitrary extra fields that other middleware wants logged
+	// alongside the standard request fields, e.g. "rate_limited": "true".
+	Attrs map[string]string
+}
+
+// SetAttr records an extra field to be logged alongside the standard
+// request fields for this request. Formats rendered as a single line
+// (LogFormatCLF, LogFormatCombined, LogFormatCEF) leave these fields out.
+func (lrc *loggingRequestContext) SetAttr(key, value string) {
+	if lrc.Attrs == nil {
+		lrc.Attrs = map[string]string{}
+	}
+	lrc.Attrs[key] = value
 }
 
 type LoggingMiddleware struct {
 	logger    *slog.Logger
 	httpPort  int
 	httpsPort int
+	options   LoggingOptions
+	sampler   *logSampler
+	redactor  *logRedactor
 	next      http.Handler
 }
 
+// LoggingOptions configures the format, volume and content of the access
+// logs written by LoggingMiddleware. The zero value reproduces the
+// middleware's original behaviour: every request logged, in whatever format
+// the injected slog.Logger's handler produces, with nothing redacted.
+//
+// This repo slice only contains internal/server: there is no service config
+// or CLI layer here to parse LoggingOptions out of, so wiring it up as
+// per-service configuration is left to the caller that owns those surfaces.
+type LoggingOptions struct {
+	// Format selects how each line is rendered. Defaults to leaving it to the
+	// injected slog.Logger's handler (LogFormatJSON or LogFormatLogfmt
+	// behave the same way).
+	Format LogFormat
+	// SampleRates maps a status class ("2xx", "4xx", "5xx", ...) to the
+	// fraction of matching requests that should be logged, from 0.0 to 1.0.
+	// Classes absent from the map are always logged.
+	SampleRates map[string]float64
+	// RedactedHeaders lists request/response header names whose values are
+	// replaced by a fixed sentinel before logging.
+	RedactedHeaders []string
+	// RedactedQueryParams lists query-string parameter names whose values
+	// are replaced by a fixed sentinel before logging.
+	RedactedQueryParams []string
+	// SlowRequestThreshold escalates the log level to WARN when a request's
+	// elapsed time exceeds it. Zero disables escalation.
+	SlowRequestThreshold time.Duration
+}
+
 // WithLoggingMiddleware should be called before other middleware because it
 // keeps track of the starting time and it also sets the `loggingRequestContext`
 // for other middleware to be able to manipulate.
@@ -50,12 +94,18 @@ type LoggingMiddleware struct {
 // (`http.ResponseWriter` along with optional interfaces such as `http.Flusher`
 // and `http.Hijacker`).
 //
+// opts controls the log format, sampling, redaction and slow-request
+// escalation; see LoggingOptions.
+//
 // Apart from logging this middleware also tracks some metrics via Prometheus.
-func WithLoggingMiddleware(logger *slog.Logger, httpPort, httpsPort int, next http.Handler) http.Handler {
+func WithLoggingMiddleware(logger *slog.Logger, httpPort, httpsPort int, opts LoggingOptions, next http.Handler) http.Handler {
 	return &LoggingMiddleware{
 		logger:    logger,
 		httpPort:  httpPort,
 		httpsPort: httpsPort,
+		options:   opts,
+		sampler:   newLogSampler(opts.SampleRates),
+		redactor:  newLogRedactor(opts.RedactedHeaders, opts.RedactedQueryParams),
 		next:      next,
 	}
 }
@@ -100,6 +150,41 @@ func (h *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			remoteAddr = clientAddr
 		}
 
+		metrics.Tracker.TrackRequest(loggingRequestContext.Service, r.Method, writer.statusCode, elapsed)
+
+		if !h.sampler.Allow(writer.statusCode) {
+			return
+		}
+
+		level := slog.LevelInfo
+		if h.options.SlowRequestThreshold > 0 && elapsed > h.options.SlowRequestThreshold {
+			level = slog.LevelWarn
+		}
+
+		if h.options.Format.rendersAsLine() {
+			requestURI := r.URL.Path
+			if r.URL.RawQuery != "" {
+				requestURI += "?" + h.redactor.RedactQuery(r.URL.RawQuery)
+			}
+
+			entry := accessLogEntry{
+				ClientAddr:   clientAddr,
+				Started:      started,
+				Method:       r.Method,
+				Path:         requestURI,
+				Proto:        r.Proto,
+				StatusCode:   writer.statusCode,
+				BytesWritten: writer.bytesWritten,
+				Referer:      r.Header.Get("Referer"),
+				UserAgent:    r.Header.Get("User-Agent"),
+				Service:      loggingRequestContext.Service,
+				Target:       loggingRequestContext.Target,
+				Elapsed:      elapsed,
+			}
+			h.logger.Log(context.Background(), level, h.options.Format.renderLine(entry))
+			return
+		}
+
 		attrs := []slog.Attr{
 			slog.String("host", r.Host),
 			slog.Int("port", port),
@@ -120,14 +205,15 @@ func (h *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			slog.String("user_agent", r.Header.Get("User-Agent")),
 			slog.String("proto", r.Proto),
 			slog.String("scheme", scheme),
-			slog.String("query", r.URL.RawQuery),
+			slog.String("query", h.redactor.RedactQuery(r.URL.RawQuery)),
 		}
 
 		attrs = append(attrs, h.retrieveCustomHeaders(loggingRequestContext.RequestHeaders, r.Header, "req")...)
 		attrs = append(attrs, h.retrieveCustomHeaders(loggingRequestContext.ResponseHeaders, writer.Header(), "resp")...)
-		h.logger.LogAttrs(context.Background(), slog.LevelInfo, "Request", attrs...)
-
-		metrics.Tracker.TrackRequest(loggingRequestContext.Service, r.Method, writer.statusCode, elapsed)
+		for key, value := range loggingRequestContext.Attrs {
+			attrs = append(attrs, slog.String(key, value))
+		}
+		h.logger.LogAttrs(context.Background(), level, "Request", attrs...)
 	}()
 
 	h.next.ServeHTTP(writer, r)
@@ -138,6 +224,9 @@ func (h *LoggingMiddleware) retrieveCustomHeaders(headerNames []string, header h
 	for _, headerName := range headerNames {
 		name := prefix + "_" + strings.ReplaceAll(strings.ToLower(headerName), "-", "_")
 		value := strings.Join(header[headerName], ",")
+		if h.redactor.IsHeaderRedacted(headerName) {
+			value = redactedSentinel
+		}
 		attrs = append(attrs, slog.String(name, value))
 	}
 	return attrs
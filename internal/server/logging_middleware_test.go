@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// capturingHandler records the rendered message of every log record, so
+// tests can assert on the exact line produced by a line-rendered LogFormat.
+type capturingHandler struct {
+	messages []string
+}
+
+func (h *capturingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *capturingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.messages = append(h.messages, r.Message)
+	return nil
+}
+func (h *capturingHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h *capturingHandler) WithGroup(name string) slog.Handler       { return h }
+
+func TestLoggingMiddleware_CLFIncludesRedactedQueryString(t *testing.T) {
+	handler := &capturingHandler{}
+	logger := slog.New(handler)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	opts := LoggingOptions{
+		Format:              LogFormatCLF,
+		RedactedQueryParams: []string{"token"},
+	}
+	h := WithLoggingMiddleware(logger, 80, 443, opts, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/search?q=widgets&token=secret123", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if len(handler.messages) != 1 {
+		t.Fatalf("got %d log messages, want 1", len(handler.messages))
+	}
+
+	line := handler.messages[0]
+	wantFragment := `"GET /search?q=widgets&token=%5BREDACTED%5D HTTP/1.1"`
+	if !strings.Contains(line, wantFragment) {
+		t.Fatalf("log line = %q, want it to contain %q", line, wantFragment)
+	}
+}
+
+func TestLoggingMiddleware_CLFOmitsQueryStringWhenAbsent(t *testing.T) {
+	handler := &capturingHandler{}
+	logger := slog.New(handler)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := WithLoggingMiddleware(logger, 80, 443, LoggingOptions{Format: LogFormatCLF}, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/search", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	line := handler.messages[0]
+	wantFragment := `"GET /search HTTP/1.1"`
+	if !strings.Contains(line, wantFragment) {
+		t.Fatalf("log line = %q, want it to contain %q", line, wantFragment)
+	}
+}
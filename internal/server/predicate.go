@@ -0,0 +1,383 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file holds the shared tokenizer, parser and AST for the small
+// expression language used by both RetryPredicate and BreakerPredicate. The
+// two predicates differ only in which context type they evaluate against and
+// which function names are callable, so everything that's actually grammar
+// (tokenizing, precedence climbing, numeric literals, function calls) lives
+// here once, parameterized on the context type.
+//
+// Grammar (lowest to highest precedence):
+//
+//	expression = or
+//	or         = and ( "||" and )*
+//	and        = unary ( "&&" unary )*
+//	unary      = "!" unary | comparison
+//	comparison = primary ( cmpOp primary )?
+//	primary    = number | ident [ "(" (expression ("," expression)*)? ")" ] | "(" expression ")"
+
+// predicateExpr evaluates to a float64 for any context type T, treating any
+// non-zero result as truthy so that boolean and numeric sub-expressions
+// share one representation.
+type predicateExpr[T any] interface {
+	Eval(ctx T, calls predicateCallTable[T]) float64
+}
+
+// predicateCallTable maps the function names callable from predicate source
+// to their implementation against a context T. Args are pre-evaluated to
+// float64; a context whose functions take no arguments (like RetryContext)
+// simply ignores them.
+type predicateCallTable[T any] map[string]func(ctx T, args []float64) float64
+
+type predicateExprLiteral[T any] struct {
+	value float64
+}
+
+func (e *predicateExprLiteral[T]) Eval(ctx T, calls predicateCallTable[T]) float64 {
+	return e.value
+}
+
+// predicateExprCall evaluates a function call like Attempts() or
+// ResponseCodeRatio(500, 600, 0, 600). Calling a name absent from the table
+// evaluates to 0, which keeps a context like RetryContext from having to
+// define entries for functions it doesn't support.
+type predicateExprCall[T any] struct {
+	name string
+	args []predicateExpr[T]
+}
+
+func (e *predicateExprCall[T]) Eval(ctx T, calls predicateCallTable[T]) float64 {
+	fn, ok := calls[e.name]
+	if !ok {
+		return 0
+	}
+	args := make([]float64, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.Eval(ctx, calls)
+	}
+	return fn(ctx, args)
+}
+
+type predicateExprNot[T any] struct {
+	operand predicateExpr[T]
+}
+
+func (e *predicateExprNot[T]) Eval(ctx T, calls predicateCallTable[T]) float64 {
+	if e.operand.Eval(ctx, calls) == 0 {
+		return 1
+	}
+	return 0
+}
+
+type predicateExprLogical[T any] struct {
+	op          string // "&&" or "||"
+	left, right predicateExpr[T]
+}
+
+func (e *predicateExprLogical[T]) Eval(ctx T, calls predicateCallTable[T]) float64 {
+	left := e.left.Eval(ctx, calls) != 0
+	var result bool
+	if e.op == "&&" {
+		result = left && e.right.Eval(ctx, calls) != 0
+	} else {
+		result = left || e.right.Eval(ctx, calls) != 0
+	}
+	if result {
+		return 1
+	}
+	return 0
+}
+
+type predicateExprComparison[T any] struct {
+	op          string
+	left, right predicateExpr[T]
+}
+
+func (e *predicateExprComparison[T]) Eval(ctx T, calls predicateCallTable[T]) float64 {
+	left := e.left.Eval(ctx, calls)
+	right := e.right.Eval(ctx, calls)
+
+	var result bool
+	switch e.op {
+	case "==":
+		result = left == right
+	case "!=":
+		result = left != right
+	case "<":
+		result = left < right
+	case "<=":
+		result = left <= right
+	case ">":
+		result = left > right
+	case ">=":
+		result = left >= right
+	}
+	if result {
+		return 1
+	}
+	return 0
+}
+
+// --- Tokenizer ---
+
+type predicateTokenKind int
+
+const (
+	predicateTokenIdent predicateTokenKind = iota
+	predicateTokenNumber
+	predicateTokenOp
+	predicateTokenLParen
+	predicateTokenRParen
+	predicateTokenComma
+)
+
+type predicateToken struct {
+	kind predicateTokenKind
+	text string
+}
+
+func tokenizePredicate(label, source string) ([]predicateToken, error) {
+	var tokens []predicateToken
+
+	i := 0
+	for i < len(source) {
+		c := source[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, predicateToken{predicateTokenLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, predicateToken{predicateTokenRParen, ")"})
+			i++
+		case c == ',':
+			tokens = append(tokens, predicateToken{predicateTokenComma, ","})
+			i++
+		case strings.HasPrefix(source[i:], "&&"):
+			tokens = append(tokens, predicateToken{predicateTokenOp, "&&"})
+			i += 2
+		case strings.HasPrefix(source[i:], "||"):
+			tokens = append(tokens, predicateToken{predicateTokenOp, "||"})
+			i += 2
+		case strings.HasPrefix(source[i:], "=="):
+			tokens = append(tokens, predicateToken{predicateTokenOp, "=="})
+			i += 2
+		case strings.HasPrefix(source[i:], "!="):
+			tokens = append(tokens, predicateToken{predicateTokenOp, "!="})
+			i += 2
+		case strings.HasPrefix(source[i:], "<="):
+			tokens = append(tokens, predicateToken{predicateTokenOp, "<="})
+			i += 2
+		case strings.HasPrefix(source[i:], ">="):
+			tokens = append(tokens, predicateToken{predicateTokenOp, ">="})
+			i += 2
+		case c == '<' || c == '>':
+			tokens = append(tokens, predicateToken{predicateTokenOp, string(c)})
+			i++
+		case c == '!':
+			tokens = append(tokens, predicateToken{predicateTokenOp, "!"})
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(source) && (source[j] >= '0' && source[j] <= '9' || source[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, predicateToken{predicateTokenNumber, source[i:j]})
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(source) && isIdentPart(source[j]) {
+				j++
+			}
+			tokens = append(tokens, predicateToken{predicateTokenIdent, source[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("%s: unexpected character %q", label, c)
+		}
+	}
+
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func isComparisonOp(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	default:
+		return false
+	}
+}
+
+// --- Recursive descent parser ---
+
+type predicateParser[T any] struct {
+	label  string
+	tokens []predicateToken
+	pos    int
+}
+
+// parsePredicate parses source into a predicateExpr[T]. label identifies the
+// calling predicate type (e.g. "retry predicate") for error messages.
+func parsePredicate[T any](label, source string) (predicateExpr[T], error) {
+	tokens, err := tokenizePredicate(label, source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &predicateParser[T]{label: label, tokens: tokens}
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if !p.atEnd() {
+		return nil, fmt.Errorf("%s: unexpected token %q", label, p.peek().text)
+	}
+
+	return expr, nil
+}
+
+func (p *predicateParser[T]) atEnd() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *predicateParser[T]) peek() predicateToken {
+	if p.atEnd() {
+		return predicateToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *predicateParser[T]) advance() predicateToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *predicateParser[T]) parseExpression() (predicateExpr[T], error) {
+	return p.parseOr()
+}
+
+func (p *predicateParser[T]) parseOr() (predicateExpr[T], error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for !p.atEnd() && p.peek().kind == predicateTokenOp && p.peek().text == "||" {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicateExprLogical[T]{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *predicateParser[T]) parseAnd() (predicateExpr[T], error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for !p.atEnd() && p.peek().kind == predicateTokenOp && p.peek().text == "&&" {
+		p.advance()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &predicateExprLogical[T]{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *predicateParser[T]) parseUnary() (predicateExpr[T], error) {
+	if !p.atEnd() && p.peek().kind == predicateTokenOp && p.peek().text == "!" {
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &predicateExprNot[T]{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *predicateParser[T]) parseComparison() (predicateExpr[T], error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.atEnd() && p.peek().kind == predicateTokenOp && isComparisonOp(p.peek().text) {
+		op := p.advance().text
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &predicateExprComparison[T]{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *predicateParser[T]) parsePrimary() (predicateExpr[T], error) {
+	if p.atEnd() {
+		return nil, fmt.Errorf("%s: unexpected end of expression", p.label)
+	}
+
+	tok := p.advance()
+
+	switch tok.kind {
+	case predicateTokenLParen:
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.atEnd() || p.peek().kind != predicateTokenRParen {
+			return nil, fmt.Errorf("%s: expected ')'", p.label)
+		}
+		p.advance()
+		return expr, nil
+	case predicateTokenNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid number %q", p.label, tok.text)
+		}
+		return &predicateExprLiteral[T]{value: value}, nil
+	case predicateTokenIdent:
+		call := &predicateExprCall[T]{name: tok.text}
+		if !p.atEnd() && p.peek().kind == predicateTokenLParen {
+			p.advance()
+			for !p.atEnd() && p.peek().kind != predicateTokenRParen {
+				arg, err := p.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if !p.atEnd() && p.peek().kind == predicateTokenComma {
+					p.advance()
+				}
+			}
+			if p.atEnd() || p.peek().kind != predicateTokenRParen {
+				return nil, fmt.Errorf("%s: expected ')' after %s(", p.label, tok.text)
+			}
+			p.advance()
+		}
+		return call, nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected token %q", p.label, tok.text)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import "testing"
+
+// fakePredicateContext implements RetryContext with fixed values, letting
+// these tests exercise the shared parser/evaluator in predicate.go without
+// depending on RetryController or CircuitBreakerMiddleware.
+type fakePredicateContext struct {
+	attempts     int
+	responseCode int
+	networkError bool
+}
+
+func (c fakePredicateContext) Attempts() int        { return c.attempts }
+func (c fakePredicateContext) ResponseCode() int    { return c.responseCode }
+func (c fakePredicateContext) IsNetworkError() bool { return c.networkError }
+
+func evalPredicate(t *testing.T, source string, ctx fakePredicateContext) bool {
+	t.Helper()
+	p, err := ParseRetryPredicate(source)
+	if err != nil {
+		t.Fatalf("ParseRetryPredicate(%q) error = %v", source, err)
+	}
+	return p.Evaluate(ctx)
+}
+
+func TestParsePredicate_OperatorPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		ctx    fakePredicateContext
+		want   bool
+	}{
+		{
+			name:   "&& binds tighter than ||",
+			source: "Attempts() == 1 || Attempts() == 2 && ResponseCode() == 500",
+			ctx:    fakePredicateContext{attempts: 1, responseCode: 200},
+			want:   true, // would be false if || bound first: (1==1 || 1==2) && 200==500
+		},
+		{
+			name:   "parentheses override precedence",
+			source: "(Attempts() == 1 || Attempts() == 2) && ResponseCode() == 500",
+			ctx:    fakePredicateContext{attempts: 1, responseCode: 200},
+			want:   false,
+		},
+		{
+			name:   "comparison binds tighter than &&",
+			source: "ResponseCode() == 502 && Attempts() <= 3",
+			ctx:    fakePredicateContext{attempts: 2, responseCode: 502},
+			want:   true,
+		},
+		{
+			name:   "! binds to the immediate comparison",
+			source: "!ResponseCode() == 200 && Attempts() == 1",
+			ctx:    fakePredicateContext{attempts: 1, responseCode: 500},
+			want:   true,
+		},
+		{
+			name:   "chained && is left-associative and all must hold",
+			source: "Attempts() >= 1 && Attempts() <= 3 && ResponseCode() != 200",
+			ctx:    fakePredicateContext{attempts: 3, responseCode: 502},
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evalPredicate(t, tt.source, tt.ctx)
+			if got != tt.want {
+				t.Fatalf("Evaluate(%q) = %v, want %v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePredicate_BooleanComposition(t *testing.T) {
+	ctx := fakePredicateContext{attempts: 1, responseCode: 0, networkError: true}
+	got := evalPredicate(t, "IsNetworkError() || ResponseCode() == 502 || Attempts() <= 3", ctx)
+	if !got {
+		t.Fatal("expected predicate to match on IsNetworkError() alone")
+	}
+}
+
+func TestParsePredicate_NumericLiteralEdgeCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{name: "integer literal", source: "Attempts() == 3", wantErr: false},
+		{name: "decimal literal", source: "Attempts() == 3.5", wantErr: false},
+		{name: "multiple decimal points", source: "Attempts() == 3.5.2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRetryPredicate(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRetryPredicate(%q) error = %v, wantErr %v", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePredicate_MalformedInput(t *testing.T) {
+	tests := []string{
+		"",
+		"(",
+		")",
+		"Attempts(",
+		"Attempts() &&",
+		"Attempts() == ",
+		"Attempts() == 1 Attempts() == 2",
+		"@@",
+	}
+
+	for _, source := range tests {
+		t.Run(source, func(t *testing.T) {
+			if _, err := ParseRetryPredicate(source); err == nil {
+				t.Fatalf("ParseRetryPredicate(%q) expected error, got nil", source)
+			}
+		})
+	}
+}
+
+func TestParsePredicate_UnknownFunctionCallEvaluatesToFalsy(t *testing.T) {
+	// An unknown function name isn't a parse error; it evaluates to 0 at
+	// runtime so a predicate written against the wrong context type (e.g. a
+	// breaker predicate accidentally used as a retry predicate) degrades to
+	// never matching, rather than panicking.
+	got := evalPredicate(t, "NotARealFunction() == 0", fakePredicateContext{})
+	if !got {
+		t.Fatal("expected unknown function call to evaluate to 0")
+	}
+}
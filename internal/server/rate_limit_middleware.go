@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/basecamp/kamal-proxy/internal/metrics"
+)
+
+// RateLimitMiddleware implements a token-bucket rate limit per identity
+// (client IP, header or cookie, via the same pluggable RolloutIdentity used
+// for rollout percentages and ConnLimitMiddleware). Buckets are stored in a
+// sharded map, sharded by a hash of the identity, to keep lock contention
+// down under a high number of distinct identities. Rejections are tracked
+// via metrics.Tracker, alongside the request-level counters LoggingMiddleware
+// already records.
+type RateLimitMiddleware struct {
+	rate  float64 // tokens added per second
+	burst float64 // bucket capacity
+
+	identity    RolloutIdentity
+	idleTimeout time.Duration
+	next        http.Handler
+
+	shards []*rateLimitShard
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastSwept time.Time
+}
+
+type rateLimitBucket struct {
+	tokens     float64
+	lastRefill time.Time
+	lastSeen   time.Time
+}
+
+// NewRateLimitMiddleware builds a RateLimitMiddleware allowing rate tokens
+// per second per identity, up to a burst of burst. Requests with no
+// extractable identity are always allowed through, since they can't be
+// attributed to a bucket. shardCount controls how many independent locks
+// the bucket map is split across; idleTimeout controls how long an unused
+// bucket is kept before being garbage collected.
+//
+// The request asked for this to be exposed as per-service configuration
+// through the kamal-proxy CLI. This repo slice only contains
+// internal/server, so there's no CLI here to surface it through; the caller
+// that owns that surface is responsible for constructing the middleware from
+// its own per-service config.
+func NewRateLimitMiddleware(rate, burst float64, identity RolloutIdentity, shardCount int, idleTimeout time.Duration, next http.Handler) *RateLimitMiddleware {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+
+	shards := make([]*rateLimitShard, shardCount)
+	for i := range shards {
+		shards[i] = &rateLimitShard{buckets: map[string]*rateLimitBucket{}}
+	}
+
+	return &RateLimitMiddleware{
+		rate:        rate,
+		burst:       burst,
+		identity:    identity,
+		idleTimeout: idleTimeout,
+		next:        next,
+		shards:      shards,
+	}
+}
+
+func (h *RateLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.identity == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	value, ok := h.identity.Identity(r)
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !h.allow(value) {
+		lrc := LoggingRequestContext(r)
+		lrc.SetAttr("rate_limited", "true")
+		metrics.Tracker.TrackRateLimited(lrc.Service)
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", h.retryAfterSeconds()))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// retryAfterSeconds estimates how long a client should wait for a single
+// token to refill. A non-positive rate never refills, so there's no finite
+// wait to report and it returns 0, which ServeHTTP still sends as the
+// Retry-After value.
+func (h *RateLimitMiddleware) retryAfterSeconds() int {
+	if h.rate <= 0 {
+		return 0
+	}
+	return int(1/h.rate) + 1
+}
+
+func (h *RateLimitMiddleware) allow(identity string) bool {
+	shard := h.shards[xxhash64(0, []byte(identity))%uint64(len(h.shards))]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := time.Now()
+	shard.sweepIdleLocked(now, h.idleTimeout)
+
+	bucket, ok := shard.buckets[identity]
+	if !ok {
+		bucket = &rateLimitBucket{tokens: h.burst, lastRefill: now}
+		shard.buckets[identity] = bucket
+	}
+
+	bucket.lastSeen = now
+	elapsed := now.Sub(bucket.lastRefill).Seconds()
+	bucket.tokens = min(h.burst, bucket.tokens+elapsed*h.rate)
+	bucket.lastRefill = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+// sweepIdleLocked removes buckets that haven't been used in idleTimeout, so
+// that memory doesn't grow unboundedly as distinct identities come and go.
+// Rather than running a background goroutine, it rides along on a regular
+// request to this shard, throttled to once per idleTimeout so the sweep
+// itself stays cheap. Callers must hold shard.mu.
+func (s *rateLimitShard) sweepIdleLocked(now time.Time, idleTimeout time.Duration) {
+	if idleTimeout <= 0 || now.Sub(s.lastSwept) < idleTimeout {
+		return
+	}
+	s.lastSwept = now
+
+	for identity, bucket := range s.buckets {
+		if now.Sub(bucket.lastSeen) >= idleTimeout {
+			delete(s.buckets, identity)
+		}
+	}
+}
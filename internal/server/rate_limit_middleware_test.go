@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimitMiddleware_AllowsUpToBurstThenRejects(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := NewRateLimitMiddleware(0, 3, NewHeaderRolloutIdentity("X-User-ID"), 1, time.Minute, next)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User-ID", "alice")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d got status %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-User-ID", "alice")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("4th request got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitMiddleware_RefillsOverTime(t *testing.T) {
+	h := NewRateLimitMiddleware(1000, 1, NewHeaderRolloutIdentity("X-User-ID"), 1, time.Minute, nil)
+
+	if !h.allow("alice") {
+		t.Fatal("expected first request to be allowed")
+	}
+	if h.allow("alice") {
+		t.Fatal("expected second immediate request to be rejected, burst exhausted")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if !h.allow("alice") {
+		t.Fatal("expected request to be allowed once tokens refill")
+	}
+}
+
+func TestRateLimitMiddleware_PerIdentityIsolation(t *testing.T) {
+	h := NewRateLimitMiddleware(0, 1, NewHeaderRolloutIdentity("X-User-ID"), 1, time.Minute, nil)
+
+	if !h.allow("alice") {
+		t.Fatal("expected alice's first request to be allowed")
+	}
+	if h.allow("alice") {
+		t.Fatal("expected alice's second request to be rejected")
+	}
+	if !h.allow("bob") {
+		t.Fatal("expected bob's bucket to be independent of alice's")
+	}
+}
+
+func TestRateLimitMiddleware_NoIdentityAlwaysAllowed(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	h := NewRateLimitMiddleware(0, 1, NewHeaderRolloutIdentity("X-User-ID"), 1, time.Minute, next)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d with no identity got status %d, want %d", i, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimitShard_SweepIdleLocked_EvictsOnlyStaleBuckets(t *testing.T) {
+	shard := &rateLimitShard{buckets: map[string]*rateLimitBucket{}}
+	now := time.Now()
+
+	shard.buckets["stale"] = &rateLimitBucket{lastSeen: now.Add(-time.Hour)}
+	shard.buckets["fresh"] = &rateLimitBucket{lastSeen: now}
+
+	shard.sweepIdleLocked(now, time.Minute)
+
+	if _, ok := shard.buckets["stale"]; ok {
+		t.Fatal("expected stale bucket to be evicted")
+	}
+	if _, ok := shard.buckets["fresh"]; !ok {
+		t.Fatal("expected fresh bucket to be kept")
+	}
+}
@@ -1,10 +1,13 @@
 package server
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
 )
 
+var contextKeyRequestBuffer = contextKey("request-buffer")
+
 // RequestBufferMiddleware buffers the request based on the Buffer
 // implementation. The maxBytes is the hard limit for buffering and exceeding that
 // will return an error. maxMemBytes represents the limit for individual
@@ -24,6 +27,15 @@ func WithRequestBufferMiddleware(maxMemBytes, maxBytes int64, next http.Handler)
 	}
 }
 
+// RequestBuffer returns the *Buffer that was used to buffer the request body,
+// so that other middleware (namely RetryController) can rewind it and
+// re-dispatch the request without re-reading from the original client
+// connection. It returns nil if the request body was never buffered.
+func RequestBuffer(r *http.Request) *Buffer {
+	buf, _ := r.Context().Value(contextKeyRequestBuffer).(*Buffer)
+	return buf
+}
+
 func (h *RequestBufferMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	requestBuffer, err := NewBufferedReadCloser(r.Body, h.maxBytes, h.maxMemBytes)
 	if err != nil {
@@ -37,5 +49,6 @@ func (h *RequestBufferMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Reque
 	}
 
 	r.Body = requestBuffer
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyRequestBuffer, requestBuffer.(*Buffer)))
 	h.next.ServeHTTP(w, r)
 }
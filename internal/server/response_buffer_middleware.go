@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HeaderNetworkError is set by the upstream dispatcher on the buffered
+// response when the target could not be reached at all (connection refused,
+// timeout, etc). RetryController uses it to answer IsNetworkError(), and it
+// is always stripped before the response is flushed to the client.
+const HeaderNetworkError = "X-Kamal-Proxy-Network-Error"
+
+// ResponseBufferMiddleware buffers the upstream response into a Buffer
+// before flushing it to the client, the same way RequestBufferMiddleware
+// does for request bodies. Buffering the full response allows an optional
+// RetryController to re-dispatch the request against a fresh target when the
+// response matches its retry predicate, without the client ever seeing the
+// failed attempt.
+//
+// Streaming responses (SSE, chunked responses that ask not to be buffered,
+// or any response that flushes before maxMemBytes is reached) bypass
+// buffering entirely and are streamed through to the client as-is.
+type ResponseBufferMiddleware struct {
+	maxMemBytes int64
+	maxBytes    int64
+	retry       *RetryController
+	next        http.Handler
+}
+
+// WithResponseBufferMiddleware wraps next so that its response is buffered
+// before being sent to the client. If retry is non-nil, buffered responses
+// that match its predicate are retried by re-invoking next, up to its
+// configured maximum attempts.
+func WithResponseBufferMiddleware(maxMemBytes, maxBytes int64, retry *RetryController, next http.Handler) http.Handler {
+	return &ResponseBufferMiddleware{
+		maxMemBytes: maxMemBytes,
+		maxBytes:    maxBytes,
+		retry:       retry,
+		next:        next,
+	}
+}
+
+func (h *ResponseBufferMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.retry != nil {
+		h.retry.Dispatch(w, r, h.maxMemBytes, h.maxBytes, h.next)
+		return
+	}
+
+	bw := newBufferedResponseWriter(w, h.maxMemBytes, h.maxBytes)
+	defer bw.Close()
+
+	h.next.ServeHTTP(bw, r)
+
+	if !bw.streaming {
+		if err := bw.flushToClient(); err != nil {
+			slog.Error("Error flushing buffered response", "path", r.URL.Path, "error", err)
+		}
+	}
+}
+
+// streamingContentTypes are response content types that are always streamed
+// through, regardless of size, because buffering them in full would either
+// break the protocol (event streams are meant to be long-lived) or add
+// needless latency.
+var streamingContentTypes = []string{
+	"text/event-stream",
+}
+
+// bufferedResponseWriter captures a handler's response into a Buffer so it
+// can be inspected (and potentially discarded and retried) before being sent
+// to the real client. It falls back to passing writes straight through once
+// it detects a response that shouldn't be buffered.
+type bufferedResponseWriter struct {
+	dest http.ResponseWriter
+
+	maxMemBytes int64
+	maxBytes    int64
+
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+
+	buffer    *Buffer
+	streaming bool
+}
+
+func newBufferedResponseWriter(dest http.ResponseWriter, maxMemBytes, maxBytes int64) *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		dest:        dest,
+		maxMemBytes: maxMemBytes,
+		maxBytes:    maxBytes,
+		header:      http.Header{},
+		statusCode:  http.StatusOK,
+		buffer:      NewBufferedWriteCloser(maxBytes, maxMemBytes),
+	}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = statusCode
+
+	if w.shouldStream() {
+		w.switchToStreaming()
+	}
+}
+
+func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.streaming {
+		return w.dest.Write(p)
+	}
+
+	n, err := w.buffer.Write(p)
+	if errors.Is(err, ErrMaximumSizeExceeded) {
+		// The response grew past the hard limit: give up on buffering it and
+		// stream the remainder through as-is. switchToStreaming sends whatever
+		// was already buffered first, so the client still gets the whole body,
+		// but the response can no longer be retried.
+		w.switchToStreaming()
+		return w.dest.Write(p)
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher. The first call during the buffering phase
+// is treated as a signal that the handler wants to stream incrementally, so
+// we bypass buffering for the rest of the response, matching pre-buffering
+// behaviour.
+func (w *bufferedResponseWriter) Flush() {
+	if !w.streaming {
+		w.switchToStreaming()
+	}
+	if flusher, ok := w.dest.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *bufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.dest.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter does not implement http.Hijacker")
+	}
+	return hijacker.Hijack()
+}
+
+// shouldStream reports whether the response just given a status code and
+// headers should bypass buffering based on its X-Accel-Buffering header or
+// its declared content type.
+func (w *bufferedResponseWriter) shouldStream() bool {
+	if w.header.Get("X-Accel-Buffering") == "no" {
+		return true
+	}
+
+	contentType := w.header.Get("Content-Type")
+	for _, streamingType := range streamingContentTypes {
+		if strings.HasPrefix(contentType, streamingType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// switchToStreaming flushes whatever has already been buffered straight to
+// the real ResponseWriter, then marks the writer so that all further writes
+// bypass the Buffer entirely.
+func (w *bufferedResponseWriter) switchToStreaming() {
+	if w.streaming {
+		return
+	}
+	w.streaming = true
+
+	w.header.Del(HeaderNetworkError)
+	copyHeaders(w.dest.Header(), w.header)
+	w.dest.WriteHeader(w.statusCode)
+
+	if err := w.buffer.Send(w.dest); err != nil {
+		slog.Error("Error flushing partially buffered response", "error", err)
+	}
+}
+
+// IsNetworkError reports whether the attempt failed to reach the upstream
+// target at all, as signalled via HeaderNetworkError.
+func (w *bufferedResponseWriter) IsNetworkError() bool {
+	return w.header.Get(HeaderNetworkError) != ""
+}
+
+// flushToClient sends the buffered status, headers and body to the real
+// ResponseWriter. It is a no-op if the response already streamed through.
+func (w *bufferedResponseWriter) flushToClient() error {
+	if w.streaming {
+		return nil
+	}
+
+	w.header.Del(HeaderNetworkError)
+	copyHeaders(w.dest.Header(), w.header)
+	w.dest.WriteHeader(w.statusCode)
+
+	return w.buffer.Send(w.dest)
+}
+
+// Close releases any resources (such as a disk spill file) held by the
+// buffered response.
+func (w *bufferedResponseWriter) Close() error {
+	return w.buffer.Close()
+}
+
+func copyHeaders(dest, src http.Header) {
+	for key, values := range src {
+		dest[key] = values
+	}
+}
+
+var _ io.Writer = (*bufferedResponseWriter)(nil)
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseBufferMiddleware_BuffersAndFlushesNormalResponse(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Custom", "value")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	h := WithResponseBufferMiddleware(1024, 1024, nil, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if got := w.Body.String(); got != "hello" {
+		t.Fatalf("body = %q, want %q", got, "hello")
+	}
+	if got := w.Header().Get("X-Custom"); got != "value" {
+		t.Fatalf("X-Custom header = %q, want %q", got, "value")
+	}
+}
+
+func TestBufferedResponseWriter_StreamsEventStreamContentType(t *testing.T) {
+	dest := httptest.NewRecorder()
+	bw := newBufferedResponseWriter(dest, 1024, 1024)
+
+	bw.Header().Set("Content-Type", "text/event-stream")
+	bw.WriteHeader(http.StatusOK)
+	bw.Write([]byte("data: hello\n\n"))
+
+	if !bw.streaming {
+		t.Fatal("expected bufferedResponseWriter to switch to streaming for text/event-stream")
+	}
+	if got := dest.Body.String(); got != "data: hello\n\n" {
+		t.Fatalf("dest body = %q, want it written straight through", got)
+	}
+}
+
+func TestBufferedResponseWriter_StreamsOnXAccelBufferingNo(t *testing.T) {
+	dest := httptest.NewRecorder()
+	bw := newBufferedResponseWriter(dest, 1024, 1024)
+
+	bw.Header().Set("X-Accel-Buffering", "no")
+	bw.WriteHeader(http.StatusOK)
+
+	if !bw.streaming {
+		t.Fatal("expected X-Accel-Buffering: no to switch the writer to streaming")
+	}
+}
+
+func TestBufferedResponseWriter_FlushSwitchesToStreaming(t *testing.T) {
+	dest := httptest.NewRecorder()
+	bw := newBufferedResponseWriter(dest, 1024, 1024)
+
+	bw.Write([]byte("first chunk"))
+	bw.Flush()
+
+	if !bw.streaming {
+		t.Fatal("expected an explicit Flush to switch the writer to streaming")
+	}
+	if got := dest.Body.String(); got != "first chunk" {
+		t.Fatalf("dest body = %q, want the buffered prefix flushed through", got)
+	}
+
+	bw.Write([]byte(" second chunk"))
+	if got := dest.Body.String(); got != "first chunk second chunk" {
+		t.Fatalf("dest body = %q, want subsequent writes to pass through directly", got)
+	}
+}
+
+func TestBufferedResponseWriter_OverflowFallsBackToStreaming(t *testing.T) {
+	dest := httptest.NewRecorder()
+	bw := newBufferedResponseWriter(dest, 1024, 4)
+
+	bw.Write([]byte("this is more than four bytes"))
+
+	if !bw.streaming {
+		t.Fatal("expected exceeding maxBytes to fall back to streaming")
+	}
+	if got := dest.Body.String(); got != "this is more than four bytes" {
+		t.Fatalf("dest body = %q, want the full write to have reached the client", got)
+	}
+}
+
+func TestBufferedResponseWriter_IsNetworkErrorReflectsHeader(t *testing.T) {
+	dest := httptest.NewRecorder()
+	bw := newBufferedResponseWriter(dest, 1024, 1024)
+
+	if bw.IsNetworkError() {
+		t.Fatal("expected IsNetworkError() to be false with no marker header")
+	}
+
+	bw.Header().Set(HeaderNetworkError, "true")
+	if !bw.IsNetworkError() {
+		t.Fatal("expected IsNetworkError() to be true once the marker header is set")
+	}
+}
+
+func TestBufferedResponseWriter_FlushToClientStripsNetworkErrorHeader(t *testing.T) {
+	dest := httptest.NewRecorder()
+	bw := newBufferedResponseWriter(dest, 1024, 1024)
+
+	bw.Header().Set(HeaderNetworkError, "true")
+	bw.WriteHeader(http.StatusBadGateway)
+	bw.Write([]byte("body"))
+
+	if err := bw.flushToClient(); err != nil {
+		t.Fatalf("flushToClient() error = %v", err)
+	}
+
+	if got := dest.Header().Get(HeaderNetworkError); got != "" {
+		t.Fatalf("HeaderNetworkError leaked to the client: %q", got)
+	}
+	if got, _ := io.ReadAll(dest.Body); string(got) != "body" {
+		t.Fatalf("dest body = %q, want %q", got, "body")
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// RetryController wraps the dispatch of a request to an upstream handler
+// (typically a ReverseProxy), re-dispatching buffered responses that match
+// its RetryPredicate, up to maxAttempts.
+//
+// It relies on RequestBufferMiddleware having already buffered the request
+// body, since that body needs to be re-sent on every attempt.
+type RetryController struct {
+	predicate   *RetryPredicate
+	maxAttempts int
+}
+
+// NewRetryController builds a RetryController that dispatches a request at
+// most maxAttempts times in total, the first attempt included, retrying as
+// long as predicate matches the buffered response.
+func NewRetryController(predicate *RetryPredicate, maxAttempts int) *RetryController {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	return &RetryController{
+		predicate:   predicate,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Dispatch invokes next repeatedly, buffering each response attempt, until
+// either a response doesn't match the retry predicate, the maximum number of
+// attempts is reached, or the response turns out to be a streaming one that
+// bypasses buffering altogether.
+func (c *RetryController) Dispatch(w http.ResponseWriter, r *http.Request, maxMemBytes, maxBytes int64, next http.Handler) {
+	requestBuffer := RequestBuffer(r)
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			if err := c.rewindRequestBody(r, requestBuffer); err != nil {
+				slog.Error("Error rewinding request body for retry", "path", r.URL.Path, "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		bw := newBufferedResponseWriter(w, maxMemBytes, maxBytes)
+		next.ServeHTTP(bw, r)
+
+		if bw.streaming || attempt >= c.maxAttempts || c.predicate == nil || !c.predicate.Evaluate(retryAttempt{bw, attempt}) {
+			if err := bw.flushToClient(); err != nil {
+				slog.Error("Error flushing buffered response", "path", r.URL.Path, "error", err)
+			}
+			bw.Close()
+			return
+		}
+
+		bw.Close()
+	}
+}
+
+func (c *RetryController) rewindRequestBody(r *http.Request, requestBuffer *Buffer) error {
+	if requestBuffer == nil {
+		return nil
+	}
+
+	reader, err := requestBuffer.Rewind()
+	if err != nil {
+		return err
+	}
+
+	r.Body = io.NopCloser(reader)
+	return nil
+}
+
+// retryAttempt adapts a single dispatch attempt to the RetryContext
+// interface expected by RetryPredicate.
+type retryAttempt struct {
+	response *bufferedResponseWriter
+	attempt  int
+}
+
+func (a retryAttempt) Attempts() int {
+	return a.attempt
+}
+
+func (a retryAttempt) ResponseCode() int {
+	if a.response.IsNetworkError() {
+		return 0
+	}
+	return a.response.statusCode
+}
+
+func (a retryAttempt) IsNetworkError() bool {
+	return a.response.IsNetworkError()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryController_RetriesUntilPredicateStopsMatching(t *testing.T) {
+	var attempts int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("ReadAll(r.Body) error = %v", err)
+		}
+		if string(body) != "original body" {
+			t.Fatalf("attempt %d: request body = %q, want %q", attempts, body, "original body")
+		}
+
+		n := atomic.AddInt32(&attempts, 1)
+		if n < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("success"))
+	})
+
+	predicate, err := ParseRetryPredicate("ResponseCode() == 502")
+	if err != nil {
+		t.Fatalf("ParseRetryPredicate() error = %v", err)
+	}
+	retry := NewRetryController(predicate, 5)
+
+	full := WithRequestBufferMiddleware(1024, 1024,
+		WithResponseBufferMiddleware(1024, 1024, retry, next))
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("original body"))
+	w := httptest.NewRecorder()
+	full.ServeHTTP(w, r)
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("attempts = %d, want 3", got)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("final status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Body.String() != "success" {
+		t.Fatalf("final body = %q, want %q", w.Body.String(), "success")
+	}
+}
+
+func TestRetryController_StopsAtMaxAttempts(t *testing.T) {
+	var attempts int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	predicate, err := ParseRetryPredicate("ResponseCode() == 502")
+	if err != nil {
+		t.Fatalf("ParseRetryPredicate() error = %v", err)
+	}
+	retry := NewRetryController(predicate, 2)
+
+	full := WithResponseBufferMiddleware(1024, 1024, retry, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	full.ServeHTTP(w, r)
+
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Fatalf("attempts = %d, want maxAttempts=2", got)
+	}
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("final status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
+
+func TestRetryController_DoesNotRetryWhenPredicateDoesNotMatch(t *testing.T) {
+	var attempts int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	predicate, err := ParseRetryPredicate("ResponseCode() == 502")
+	if err != nil {
+		t.Fatalf("ParseRetryPredicate() error = %v", err)
+	}
+	retry := NewRetryController(predicate, 5)
+
+	full := WithResponseBufferMiddleware(1024, 1024, retry, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	full.ServeHTTP(w, r)
+
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("attempts = %d, want 1 (no retry on non-matching response)", got)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("final status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRetryController_StreamingResponseBypassesRetry(t *testing.T) {
+	var attempts int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("data: chunk\n\n"))
+	})
+
+	predicate, err := ParseRetryPredicate("ResponseCode() == 502")
+	if err != nil {
+		t.Fatalf("ParseRetryPredicate() error = %v", err)
+	}
+	retry := NewRetryController(predicate, 5)
+
+	full := WithResponseBufferMiddleware(1024, 1024, retry, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	full.ServeHTTP(w, r)
+
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Fatalf("attempts = %d, want 1: a streaming response must bypass retry even if the predicate matches", got)
+	}
+}
+
+func TestRetryController_NetworkErrorTriggersRetry(t *testing.T) {
+	var attempts int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&attempts, 1)
+		if n < 2 {
+			w.Header().Set(HeaderNetworkError, "true")
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	predicate, err := ParseRetryPredicate("IsNetworkError()")
+	if err != nil {
+		t.Fatalf("ParseRetryPredicate() error = %v", err)
+	}
+	retry := NewRetryController(predicate, 3)
+
+	full := WithResponseBufferMiddleware(1024, 1024, retry, next)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	full.ServeHTTP(w, r)
+
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Fatalf("attempts = %d, want 2", got)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("final status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get(HeaderNetworkError); got != "" {
+		t.Fatalf("HeaderNetworkError leaked to the client: %q", got)
+	}
+}
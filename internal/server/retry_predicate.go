@@ -0,0 +1,59 @@
+package server
+
+// RetryContext exposes the facts a RetryPredicate can be evaluated against.
+// A new RetryContext is built for every dispatch attempt.
+type RetryContext interface {
+	// Attempts returns the number of dispatch attempts made so far, starting at 1
+	// for the first attempt.
+	Attempts() int
+	// ResponseCode returns the HTTP status code of the buffered response, or 0 if
+	// the attempt never produced one (e.g. a network error).
+	ResponseCode() int
+	// IsNetworkError returns true if the attempt failed before a response could
+	// be read from the upstream target.
+	IsNetworkError() bool
+}
+
+// RetryPredicate is a small boolean expression evaluated against a
+// RetryContext to decide whether a buffered response should be retried.
+//
+// The expression language supports the function calls `Attempts()`,
+// `ResponseCode()` and `IsNetworkError()`, the comparison operators
+// `== != < <= > >=`, the boolean operators `&& ||`, unary `!`, parentheses,
+// and numeric literals. For example:
+//
+//	IsNetworkError() || ResponseCode() == 502 || Attempts() <= 3
+//
+// The grammar, tokenizer and parser are shared with BreakerPredicate; see
+// predicate.go.
+type RetryPredicate struct {
+	expr predicateExpr[RetryContext]
+}
+
+// retryCallTable binds the function names callable from a RetryPredicate to
+// RetryContext, as 0-argument calls.
+var retryCallTable = predicateCallTable[RetryContext]{
+	"Attempts":     func(ctx RetryContext, args []float64) float64 { return float64(ctx.Attempts()) },
+	"ResponseCode": func(ctx RetryContext, args []float64) float64 { return float64(ctx.ResponseCode()) },
+	"IsNetworkError": func(ctx RetryContext, args []float64) float64 {
+		if ctx.IsNetworkError() {
+			return 1
+		}
+		return 0
+	},
+}
+
+// ParseRetryPredicate parses source into a *RetryPredicate ready to be
+// evaluated. It returns an error if the expression is malformed.
+func ParseRetryPredicate(source string) (*RetryPredicate, error) {
+	expr, err := parsePredicate[RetryContext]("retry predicate", source)
+	if err != nil {
+		return nil, err
+	}
+	return &RetryPredicate{expr: expr}, nil
+}
+
+// Evaluate runs the predicate against the given RetryContext.
+func (p *RetryPredicate) Evaluate(ctx RetryContext) bool {
+	return p.expr.Eval(ctx, retryCallTable) != 0
+}
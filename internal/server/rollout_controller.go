@@ -1,38 +1,74 @@
 package server
 
 import (
-	"hash/fnv"
 	"net/http"
 	"slices"
 )
 
 const RolloutCookieName = "kamal-rollout"
 
+// HealthGate reports whether a target is currently fit to receive traffic.
+// CircuitBreakerMiddleware satisfies this, letting a tripped breaker exclude
+// its target from rollout selection without RolloutController needing to
+// know anything about breakers.
+type HealthGate interface {
+	Healthy() bool
+}
+
 type RolloutController struct {
 	Percentage           int      `json:"percentage"`
 	PercentageSplitPoint float64  `json:"percentage_split_point"`
 	Allowlist            []string `json:"allowlist"`
+	Seed                 uint64   `json:"seed"`
+
+	identity            RolloutIdentity
+	rolloutTargetHealth HealthGate
 }
 
 // NewRolloutController will initialize the *RolloutController with the params
 // but also setup the split percentage.
-// It does that by computing the maximum 32-bit hash value and converting it to
+// It does that by computing the maximum 64-bit hash value and converting it to
 // float64 for precision, based on the percentage param.
-func NewRolloutController(percentage int, allowlist []string) *RolloutController {
-	maxHashValue := float64(uint32(0xFFFFFFFF))
+//
+// identity determines where a request's rollout identity is read from; pass
+// nil to fall back to the original kamal-rollout cookie. seed distinguishes
+// independent concurrent rollouts on the same service so they don't all
+// bucket the same users together.
+func NewRolloutController(percentage int, allowlist []string, identity RolloutIdentity, seed uint64) *RolloutController {
+	maxHashValue := float64(^uint64(0))
 	percentageSplitPoint := maxHashValue * (float64(percentage) / 100.0)
 
+	if identity == nil {
+		identity = NewCookieRolloutIdentity(RolloutCookieName)
+	}
+
 	return &RolloutController{
 		Percentage:           percentage,
 		PercentageSplitPoint: percentageSplitPoint,
 		Allowlist:            allowlist,
+		Seed:                 seed,
+		identity:             identity,
 	}
 }
 
+// SetRolloutTargetHealth wires a health signal, typically the
+// CircuitBreakerMiddleware guarding the rollout target, into rollout
+// selection. Once gate reports unhealthy, RequestUsesRolloutGroup returns
+// false for every request, so traffic falls back to the stable target until
+// the breaker recovers. Pass nil to stop gating on health.
+func (rc *RolloutController) SetRolloutTargetHealth(gate HealthGate) {
+	rc.rolloutTargetHealth = gate
+}
+
 // RequestUsesRolloutGroup checks if the user is in the rollout group.
 // It does that by checking if he's in the allow list or in the rollout
-// percentage. A cookie is used to determine both things.
+// percentage, both determined by the configured RolloutIdentity, as long as
+// the rollout target's health gate (if any) reports healthy.
 func (rc *RolloutController) RequestUsesRolloutGroup(r *http.Request) bool {
+	if rc.rolloutTargetHealth != nil && !rc.rolloutTargetHealth.Healthy() {
+		return false
+	}
+
 	splitValue := rc.splitValue(r)
 	if splitValue == "" {
 		return false
@@ -54,16 +90,22 @@ func (rc *RolloutController) valueInRolloutPercentage(value string) bool {
 	return float64(hash) <= rc.PercentageSplitPoint
 }
 
-func (rc *RolloutController) hashForValue(value string) uint32 {
-	hasher := fnv.New32a()
-	hasher.Write([]byte(value))
-	return hasher.Sum32()
+// hashForValue hashes value with a seeded 64-bit xxHash, so that multiple
+// concurrent rollouts on the same service, each with its own Seed, don't all
+// bucket the same identities into the same group.
+func (rc *RolloutController) hashForValue(value string) uint64 {
+	return xxhash64(rc.Seed, []byte(value))
 }
 
 func (rc *RolloutController) splitValue(r *http.Request) string {
-	cookie, err := r.Cookie(RolloutCookieName)
-	if err != nil {
+	identity := rc.identity
+	if identity == nil {
+		identity = NewCookieRolloutIdentity(RolloutCookieName)
+	}
+
+	value, ok := identity.Identity(r)
+	if !ok {
 		return ""
 	}
-	return cookie.Value
+	return value
 }
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RolloutIdentity extracts the value that a RolloutController uses to decide
+// whether a given request belongs to the rollout group. Different clients
+// (browsers, APIs, gRPC, background jobs) carry their identity in different
+// places, so the extractor is pluggable per rollout.
+type RolloutIdentity interface {
+	// Identity returns the normalized identity value for the request, and
+	// whether one could be found at all. A request with no identity never
+	// matches the allowlist or the rollout percentage.
+	Identity(r *http.Request) (string, bool)
+}
+
+// CookieRolloutIdentity reads the identity from a cookie, defaulting to
+// RolloutCookieName. This is the original, and still default, behaviour.
+type CookieRolloutIdentity struct {
+	CookieName string
+}
+
+func NewCookieRolloutIdentity(cookieName string) *CookieRolloutIdentity {
+	if cookieName == "" {
+		cookieName = RolloutCookieName
+	}
+	return &CookieRolloutIdentity{CookieName: cookieName}
+}
+
+func (i *CookieRolloutIdentity) Identity(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(i.CookieName)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// HeaderRolloutIdentity reads the identity from a request header, e.g.
+// X-User-ID, which is the natural identity carrier for API and gRPC clients
+// that don't send cookies.
+type HeaderRolloutIdentity struct {
+	HeaderName string
+}
+
+func NewHeaderRolloutIdentity(headerName string) *HeaderRolloutIdentity {
+	return &HeaderRolloutIdentity{HeaderName: headerName}
+}
+
+func (i *HeaderRolloutIdentity) Identity(r *http.Request) (string, bool) {
+	value := r.Header.Get(i.HeaderName)
+	if value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// QueryRolloutIdentity reads the identity from a query string parameter.
+type QueryRolloutIdentity struct {
+	ParamName string
+}
+
+func NewQueryRolloutIdentity(paramName string) *QueryRolloutIdentity {
+	return &QueryRolloutIdentity{ParamName: paramName}
+}
+
+func (i *QueryRolloutIdentity) Identity(r *http.Request) (string, bool) {
+	value := r.URL.Query().Get(i.ParamName)
+	if value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// ClientIPRolloutIdentity reads the identity from the client's IP address,
+// normalized to a CIDR bucket so that e.g. an entire /24 (IPv4) or /64
+// (IPv6) consistently lands in the same rollout group. This keeps clients
+// behind the same NAT or subnet together, which matters for office networks
+// and mobile carriers that rotate individual addresses.
+//
+// X-Forwarded-For is client-controlled input: anyone can set it to anything.
+// It's only safe to trust up to the number of reverse proxies kamal-proxy
+// itself sits behind, each of which appends exactly one hop to the right of
+// the header. TrustedProxyHops says how many of those trailing hops to
+// trust; entries to the left of that are attacker-controlled and ignored.
+// With TrustedProxyHops at its zero value, X-Forwarded-For is never
+// consulted and the connection's own remote address is used instead - this
+// matters because this identity is also used as the abuse-prevention key
+// for ConnLimitMiddleware and RateLimitMiddleware, where a spoofable
+// identity defeats the limit entirely.
+type ClientIPRolloutIdentity struct {
+	IPv4MaskBits     int
+	IPv6MaskBits     int
+	TrustedProxyHops int
+}
+
+// NewClientIPRolloutIdentity builds a ClientIPRolloutIdentity. trustedProxyHops
+// is the number of trusted reverse proxies in front of kamal-proxy that each
+// append to X-Forwarded-For; pass 0 if kamal-proxy receives connections
+// directly, or doesn't trust whatever sits in front of it, so only
+// r.RemoteAddr is used.
+func NewClientIPRolloutIdentity(ipv4MaskBits, ipv6MaskBits, trustedProxyHops int) *ClientIPRolloutIdentity {
+	if ipv4MaskBits <= 0 {
+		ipv4MaskBits = 32
+	}
+	if ipv6MaskBits <= 0 {
+		ipv6MaskBits = 128
+	}
+	return &ClientIPRolloutIdentity{
+		IPv4MaskBits:     ipv4MaskBits,
+		IPv6MaskBits:     ipv6MaskBits,
+		TrustedProxyHops: trustedProxyHops,
+	}
+}
+
+func (i *ClientIPRolloutIdentity) Identity(r *http.Request) (string, bool) {
+	host := i.trustedRemoteHost(r)
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+
+	maskBits := i.IPv6MaskBits
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		maskBits = i.IPv4MaskBits
+	}
+
+	mask := net.CIDRMask(maskBits, len(ip)*8)
+	return ip.Mask(mask).String(), true
+}
+
+// trustedRemoteHost returns the host part of the request's true remote
+// address, honoring X-Forwarded-For only up to the number of hops this
+// identity is configured to trust.
+func (i *ClientIPRolloutIdentity) trustedRemoteHost(r *http.Request) string {
+	remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteHost = r.RemoteAddr
+	}
+
+	if i.TrustedProxyHops <= 0 {
+		return remoteHost
+	}
+
+	forwardedFor := r.Header.Get("X-Forwarded-For")
+	if forwardedFor == "" {
+		return remoteHost
+	}
+
+	parts := strings.Split(forwardedFor, ",")
+	idx := len(parts) - i.TrustedProxyHops
+	if idx < 0 {
+		return remoteHost
+	}
+
+	return strings.TrimSpace(parts[idx])
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPRolloutIdentity_TrustedProxyHops(t *testing.T) {
+	tests := []struct {
+		name             string
+		trustedProxyHops int
+		remoteAddr       string
+		forwardedFor     string
+		want             string
+		wantOK           bool
+	}{
+		{
+			name:             "zero hops ignores X-Forwarded-For entirely",
+			trustedProxyHops: 0,
+			remoteAddr:       "10.0.0.1:1234",
+			forwardedFor:     "1.2.3.4",
+			want:             "10.0.0.1",
+			wantOK:           true,
+		},
+		{
+			name:             "one trusted hop takes the rightmost entry",
+			trustedProxyHops: 1,
+			remoteAddr:       "10.0.0.1:1234",
+			forwardedFor:     "203.0.113.9, 10.0.0.2",
+			want:             "10.0.0.2",
+			wantOK:           true,
+		},
+		{
+			name:             "two trusted hops skips the rightmost proxy-added entry",
+			trustedProxyHops: 2,
+			remoteAddr:       "10.0.0.1:1234",
+			forwardedFor:     "203.0.113.9, 198.51.100.5, 10.0.0.2",
+			want:             "198.51.100.5",
+			wantOK:           true,
+		},
+		{
+			name:             "more trusted hops than entries falls back to RemoteAddr",
+			trustedProxyHops: 5,
+			remoteAddr:       "10.0.0.1:1234",
+			forwardedFor:     "203.0.113.9, 10.0.0.2",
+			want:             "10.0.0.1",
+			wantOK:           true,
+		},
+		{
+			name:             "trusted hops configured but header absent falls back to RemoteAddr",
+			trustedProxyHops: 1,
+			remoteAddr:       "10.0.0.1:1234",
+			forwardedFor:     "",
+			want:             "10.0.0.1",
+			wantOK:           true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := NewClientIPRolloutIdentity(32, 128, tt.trustedProxyHops)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			got, ok := identity.Identity(r)
+			if ok != tt.wantOK {
+				t.Fatalf("Identity() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got != tt.want {
+				t.Fatalf("Identity() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPRolloutIdentity_CIDRBucketing(t *testing.T) {
+	identity := NewClientIPRolloutIdentity(24, 64, 0)
+
+	r1 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r1.RemoteAddr = "192.168.1.5:1234"
+	r2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r2.RemoteAddr = "192.168.1.200:5678"
+
+	v1, ok1 := identity.Identity(r1)
+	v2, ok2 := identity.Identity(r2)
+	if !ok1 || !ok2 {
+		t.Fatal("expected both addresses to yield an identity")
+	}
+	if v1 != v2 {
+		t.Fatalf("expected addresses in the same /24 to bucket together, got %q and %q", v1, v2)
+	}
+
+	r3 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r3.RemoteAddr = "192.168.2.5:9999"
+	v3, ok3 := identity.Identity(r3)
+	if !ok3 {
+		t.Fatal("expected address to yield an identity")
+	}
+	if v3 == v1 {
+		t.Fatalf("expected address in a different /24 to bucket separately, got %q for both", v1)
+	}
+}
+
+func TestClientIPRolloutIdentity_InvalidRemoteAddrHasNoIdentity(t *testing.T) {
+	identity := NewClientIPRolloutIdentity(32, 128, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "not-an-ip"
+
+	if _, ok := identity.Identity(r); ok {
+		t.Fatal("expected an unparseable RemoteAddr to yield no identity")
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func encodeJWTSegment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signHS256(t *testing.T, header, payload map[string]any, key []byte) string {
+	t.Helper()
+	signedContent := encodeJWTSegment(t, header) + "." + encodeJWTSegment(t, payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signedContent))
+	return signedContent + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, header, payload map[string]any, key *rsa.PrivateKey) string {
+	t.Helper()
+	signedContent := encodeJWTSegment(t, header) + "." + encodeJWTSegment(t, payload)
+	digest := sha256.Sum256([]byte(signedContent))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("rsa.SignPKCS1v15() error = %v", err)
+	}
+	return signedContent + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func requestWithBearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestJWTRolloutIdentity_HS256ValidSignature(t *testing.T) {
+	key := []byte("secret")
+	token := signHS256(t,
+		map[string]any{"alg": "HS256", "typ": "JWT"},
+		map[string]any{"sub": "user-123"},
+		key,
+	)
+
+	identity := NewJWTRolloutIdentity("", "sub", key, nil)
+	value, ok := identity.Identity(requestWithBearer(token))
+	if !ok {
+		t.Fatal("expected identity to be extracted")
+	}
+	if value != "user-123" {
+		t.Fatalf("Identity() = %q, want %q", value, "user-123")
+	}
+}
+
+func TestJWTRolloutIdentity_HS256WrongKeyRejected(t *testing.T) {
+	token := signHS256(t,
+		map[string]any{"alg": "HS256", "typ": "JWT"},
+		map[string]any{"sub": "user-123"},
+		[]byte("secret"),
+	)
+
+	identity := NewJWTRolloutIdentity("", "sub", []byte("not-the-secret"), nil)
+	if _, ok := identity.Identity(requestWithBearer(token)); ok {
+		t.Fatal("expected identity extraction to fail with the wrong HMAC key")
+	}
+}
+
+func TestJWTRolloutIdentity_RS256ValidSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	token := signRS256(t,
+		map[string]any{"alg": "RS256", "typ": "JWT"},
+		map[string]any{"sub": "user-456"},
+		key,
+	)
+
+	identity := NewJWTRolloutIdentity("", "sub", nil, &key.PublicKey)
+	value, ok := identity.Identity(requestWithBearer(token))
+	if !ok {
+		t.Fatal("expected identity to be extracted")
+	}
+	if value != "user-456" {
+		t.Fatalf("Identity() = %q, want %q", value, "user-456")
+	}
+}
+
+func TestJWTRolloutIdentity_RS256WrongKeyRejected(t *testing.T) {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+
+	token := signRS256(t,
+		map[string]any{"alg": "RS256", "typ": "JWT"},
+		map[string]any{"sub": "user-456"},
+		signingKey,
+	)
+
+	identity := NewJWTRolloutIdentity("", "sub", nil, &otherKey.PublicKey)
+	if _, ok := identity.Identity(requestWithBearer(token)); ok {
+		t.Fatal("expected identity extraction to fail verifying against the wrong RSA public key")
+	}
+}
+
+func TestJWTRolloutIdentity_MalformedToken(t *testing.T) {
+	identity := NewJWTRolloutIdentity("", "sub", []byte("secret"), nil)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no header present", ""},
+		{"only one segment", "justoneseemingsegment"},
+		{"missing signature segment", "aGVhZGVy.cGF5bG9hZA"},
+		{"not base64", "!!!.???.***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := identity.Identity(requestWithBearer(tt.token)); ok {
+				t.Fatalf("expected malformed token %q to be rejected", tt.token)
+			}
+		})
+	}
+}
+
+func TestJWTRolloutIdentity_UnsupportedAlgorithmRejected(t *testing.T) {
+	token := signHS256(t,
+		map[string]any{"alg": "none", "typ": "JWT"},
+		map[string]any{"sub": "user-123"},
+		[]byte("secret"),
+	)
+
+	identity := NewJWTRolloutIdentity("", "sub", []byte("secret"), nil)
+	if _, ok := identity.Identity(requestWithBearer(token)); ok {
+		t.Fatal("expected alg=none token to be rejected")
+	}
+}
+
+func TestJWTRolloutIdentity_NestedClaimPath(t *testing.T) {
+	key := []byte("secret")
+	token := signHS256(t,
+		map[string]any{"alg": "HS256", "typ": "JWT"},
+		map[string]any{"user": map[string]any{"id": "nested-789"}},
+		key,
+	)
+
+	identity := NewJWTRolloutIdentity("", "user.id", key, nil)
+	value, ok := identity.Identity(requestWithBearer(token))
+	if !ok {
+		t.Fatal("expected nested claim to be extracted")
+	}
+	if value != "nested-789" {
+		t.Fatalf("Identity() = %q, want %q", value, "nested-789")
+	}
+}
+
+func TestJWTRolloutIdentity_MissingTokenHasNoIdentity(t *testing.T) {
+	identity := NewJWTRolloutIdentity("", "sub", []byte("secret"), nil)
+	if _, ok := identity.Identity(requestWithBearer("")); ok {
+		t.Fatal("expected no Authorization header to yield no identity")
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+// A small, self-contained implementation of the 64-bit xxHash algorithm
+// (https://github.com/Cyan4973/xxHash), used by RolloutController to turn an
+// identity string into a uniformly distributed bucket. Unlike hash/maphash,
+// it's fully deterministic across process restarts for a given seed, which
+// matters here: a client's bucket must stay stable between requests.
+const (
+	xxhashPrime1 uint64 = 11400714785074694791
+	xxhashPrime2 uint64 = 14029467366897019727
+	xxhashPrime3 uint64 = 1609587929392839161
+	xxhashPrime4 uint64 = 9650029242287828579
+	xxhashPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 computes the 64-bit xxHash of data, seeded with seed.
+func xxhash64(seed uint64, data []byte) uint64 {
+	var h uint64
+	length := len(data)
+
+	if length >= 32 {
+		v1 := seed + xxhashPrime1 + xxhashPrime2
+		v2 := seed + xxhashPrime2
+		v3 := seed
+		v4 := seed - xxhashPrime1
+
+		for len(data) >= 32 {
+			v1 = xxhashRound(v1, le64(data[0:8]))
+			v2 = xxhashRound(v2, le64(data[8:16]))
+			v3 = xxhashRound(v3, le64(data[16:24]))
+			v4 = xxhashRound(v4, le64(data[24:32]))
+			data = data[32:]
+		}
+
+		h = rotl64(v1, 1) + rotl64(v2, 7) + rotl64(v3, 12) + rotl64(v4, 18)
+		h = xxhashMergeRound(h, v1)
+		h = xxhashMergeRound(h, v2)
+		h = xxhashMergeRound(h, v3)
+		h = xxhashMergeRound(h, v4)
+	} else {
+		h = seed + xxhashPrime5
+	}
+
+	h += uint64(length)
+
+	for len(data) >= 8 {
+		h ^= xxhashRound(0, le64(data[0:8]))
+		h = rotl64(h, 27)*xxhashPrime1 + xxhashPrime4
+		data = data[8:]
+	}
+
+	if len(data) >= 4 {
+		h ^= uint64(le32(data[0:4])) * xxhashPrime1
+		h = rotl64(h, 23)*xxhashPrime2 + xxhashPrime3
+		data = data[4:]
+	}
+
+	for len(data) > 0 {
+		h ^= uint64(data[0]) * xxhashPrime5
+		h = rotl64(h, 11) * xxhashPrime1
+		data = data[1:]
+	}
+
+	h ^= h >> 33
+	h *= xxhashPrime2
+	h ^= h >> 29
+	h *= xxhashPrime3
+	h ^= h >> 32
+
+	return h
+}
+
+func xxhashRound(acc, input uint64) uint64 {
+	acc += input * xxhashPrime2
+	acc = rotl64(acc, 31)
+	acc *= xxhashPrime1
+	return acc
+}
+
+func xxhashMergeRound(acc, val uint64) uint64 {
+	val = xxhashRound(0, val)
+	acc ^= val
+	acc = acc*xxhashPrime1 + xxhashPrime4
+	return acc
+}
+
+func rotl64(x uint64, r uint) uint64 {
+	return (x << r) | (x >> (64 - r))
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func le64(b []byte) uint64 {
+	return uint64(le32(b[0:4])) | uint64(le32(b[4:8]))<<32
+}